@@ -0,0 +1,101 @@
+package client
+
+import (
+	"context"
+	"fastRPC/conn"
+)
+
+/*
+批量调用
+Batch 将多个调用打包为一个请求发送（格式见 conn/batch.go），服务端并发执行后一次性回复，
+适合一次需要发起大量小调用的场景，可以节省往返的次数：
+
+	calls := []*client.BatchCall{
+		{ServiceMethod: "Foo.Sum", Args: &Args{1, 2}, Reply: new(int)},
+		{ServiceMethod: "Foo.Sum", Args: &Args{3, 4}, Reply: new(int)},
+	}
+	if err := c.Batch(ctx, calls); err != nil {
+		return err // the whole batch failed
+	}
+	for _, call := range calls {
+		if call.Error != nil { ... }
+	}
+
+Batch 返回的错误表示整个批量请求失败，此时所有项的 Error 都是该错误；否则每一项的错误保存在 BatchCall.Error 中。
+批量请求作为一个整体经过 UnaryClientInterceptor，ServiceMethod 为 BatchServiceMethod，args 为 []*BatchCall。
+*/
+
+// BatchServiceMethod 批量调用经过拦截器时的 serviceMethod
+const BatchServiceMethod = "$batch"
+
+// BatchCall 批量调用中的一项
+type BatchCall struct {
+	ServiceMethod string      // format "<service>.<method>"
+	Args          interface{} // arguments to the function
+	Reply         interface{} // reply from the function
+	Error         error       // if error occurs, it will be set
+}
+
+// Batch invokes several functions in a single round trip, the error of each call is set to BatchCall.Error.
+func (c *Client) Batch(ctx context.Context, calls []*BatchCall) error {
+	err := c.chainInterceptors(c.batch)(ctx, BatchServiceMethod, calls, nil)
+	if err != nil {
+		for _, call := range calls {
+			call.Error = err
+		}
+	}
+	return err
+}
+
+// batch 编码每一项的参数，发送批量请求并解码每一项的结果，是批量调用的 invoker
+func (c *Client) batch(ctx context.Context, _ string, args, _ interface{}) error {
+	calls, ok := args.([]*BatchCall)
+	if !ok {
+		return conn.Errorf(conn.InvalidArgument, "FastRPC client: expect []*BatchCall, but got %T", args)
+	}
+	if !c.opt.Capabilities.Has(conn.CapBatch) {
+		return conn.NewError(conn.Unimplemented, "FastRPC client: batch call is not supported by the server")
+	}
+	vc, ok := c.cliConn.(conn.ValueCodec)
+	if !ok {
+		return conn.NewError(conn.Unimplemented, "FastRPC client: the codec does not support batch calls")
+	}
+	if len(calls) > conn.MaxBatchSize {
+		return conn.Errorf(conn.InvalidArgument, "FastRPC client: too many calls in a batch: %d > %d", len(calls), conn.MaxBatchSize)
+	}
+
+	items := make([]conn.BatchItem, len(calls))
+	for i, call := range calls {
+		items[i].ServiceMethod = call.ServiceMethod
+		if call.Args == nil {
+			continue
+		}
+		data, err := vc.Marshal(call.Args)
+		if err != nil {
+			return conn.Errorf(conn.InvalidArgument, "FastRPC client: encode args of %s error: %v", call.ServiceMethod, err)
+		}
+		items[i].Args = data
+	}
+
+	var results []conn.BatchResult
+	call := newCall(ctx, "", items, &results, make(chan *Call, 1))
+	call.kind = conn.KindBatch
+	c.send(ctx, call)
+	if err := c.wait(ctx, call); err != nil {
+		return err
+	}
+	if len(results) != len(calls) {
+		return conn.Errorf(conn.Internal, "FastRPC client: expect %d results, but got %d", len(calls), len(results))
+	}
+
+	for i, call := range calls {
+		call.Error = results[i].Err()
+		if call.Error != nil || call.Reply == nil {
+			continue
+		}
+		if err := vc.Unmarshal(results[i].Reply, call.Reply); err != nil {
+			call.Error = conn.Errorf(conn.Internal, "FastRPC client: decode reply of %s error: %v", call.ServiceMethod, err)
+		}
+	}
+	return nil
+}
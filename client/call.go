@@ -1,5 +1,11 @@
 package client
 
+import (
+	"fastRPC/conn"
+	"fastRPC/metadata"
+	"time"
+)
+
 // Call represents an active RPC.
 // 封装了结构体 Call 来承载一次 RPC 调用所需要的信息
 type Call struct {
@@ -9,6 +15,12 @@ type Call struct {
 	Reply         interface{} // reply from the function
 	Error         error       // if error occurs, it will be set
 
+	Metadata      metadata.MD // metadata sent with the request
+	ReplyMetadata metadata.MD // metadata received with the response
+	deadline      time.Time   // the caller's deadline, propagated to the server
+	stream        *Stream     // not nil for streaming calls, messages are delivered to the stream
+	kind          conn.Kind   // KindCall, or KindBatch for batch calls
+
 	// 1. 为了支持异步调用，当调用结束时，Client会调用 call.done() 通知调用方
 	// 2. 当前RPC调用还未完成时，Client出现故障，Client会调用 call.done() 通知调用方
 	Done chan *Call // Strobes when call is complete.
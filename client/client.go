@@ -2,9 +2,9 @@ package client
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fastRPC/conn"
+	"fastRPC/metadata"
 	"fmt"
 	"log"
 	"net"
@@ -13,8 +13,9 @@ import (
 )
 
 var (
-	ErrConnClosed       = errors.New("connection already closed")
-	ErrConnNotAvailable = errors.New("connection not available")
+	ErrConnClosed             = errors.New("connection already closed")
+	ErrConnNotAvailable error = conn.NewError(conn.Unavailable, "connection not available")
+	ErrConnLost         error = conn.NewError(conn.Unavailable, "connection lost") // 连接意外断开时，未完成的请求返回该错误
 )
 
 // Client 客户端最核心部分
@@ -37,34 +38,62 @@ type Client struct {
 	pending  map[uint64]*Call // 存储未处理完的请求，键是编号，值是 Call 实例
 	closing  bool             // user has called Close()
 	shutdown bool             // server has told us to stop
+
+	interceptors []UnaryClientInterceptor // 调用经过的拦截器，由 Use 注册
+
+	// for flow control, see flow.go
+	flow       bool          // CapFlowControl is negotiated
+	credits    int           // requests that can be sent before the server grants more, protected by mu
+	creditWake chan struct{} // credits is increased
+
+	pushHandlers map[string]*pushHandler // handlers of pushed messages by topic, protected by mu, see push.go
+	services     sync.Map                // services registered for reverse calls, see reverse.go
+
+	done     chan struct{} // closed when the client becomes unavailable
+	doneOnce sync.Once
 }
 
 // NewClient Client构造函数
 // 创建 Client 实例时，首先需要完成一开始的协议交换，即发送 Option 信息给服务端。
 // 协商好消息的编解码方式之后，再创建一个子协程调用 receive() 接收响应。
 func NewClient(nc net.Conn, opt *conn.Option) (*Client, error) {
-	f := conn.NewConnFuncMap[opt.ConnType]
-	if f == nil {
+	f, ok := conn.LookupCodec(opt.ConnType)
+	if !ok {
 		err := fmt.Errorf("invalid connection type %s", opt.ConnType)
 		log.Println("FastRPC client: connection type error:", err)
 		return nil, err
 	}
 
 	// send options with server
-	if err := json.NewEncoder(nc).Encode(opt); err != nil {
+	if err := conn.WriteOption(nc, opt); err != nil {
 		log.Println("FastRPC client: option encode error: ", err)
 		_ = nc.Close()
 		return nil, err
 	}
 
-	// TODO: 解决粘包问题
-	if err := json.NewDecoder(nc).Decode(opt); err != nil {
+	// ReadOption 只会读走服务端回复的 Option，之后的响应都由 Framer 按帧读取
+	opt, err := conn.ReadOption(nc)
+	if err != nil {
 		log.Printf("FastRPC client: option decode error: %s", err.Error())
 		_ = nc.Close()
 		return nil, err
 	}
+	if opt.Error != "" || opt.Version < conn.MinProtocolVersion {
+		err = fmt.Errorf("FastRPC client: connection rejected: %s (protocol version %d)", opt.Error, opt.Version)
+		log.Println(err)
+		_ = nc.Close()
+		return nil, err
+	}
 
-	return newClientConn(f(nc), opt), nil
+	// 使用协商后的压缩算法
+	framer := conn.NewFramer(nc)
+	if err := framer.SetCompression(opt); err != nil {
+		log.Println("FastRPC client: compression error:", err)
+		_ = nc.Close()
+		return nil, err
+	}
+
+	return newClientConn(f(framer), opt), nil
 }
 
 type newClientFunc func(nc net.Conn, opt *conn.Option) (client *Client, err error)
@@ -126,14 +155,33 @@ func Dial(network, address string, opts ...*conn.Option) (*Client, error) {
 	ctx, _ := context.WithTimeout(context.Background(), time.Second)
 	var reply int
  	err := client.Call(ctx, "Foo.Sum", &Args{1, 2}, &reply)
+
+   ctx 中通过 metadata.NewOutgoingContext 附加的元数据会随请求一起发送给服务端，
+   响应的元数据写入 metadata.WithReplyHolder 附加的 MD 中
+   ctx 的 deadline 也会告知服务端，服务端超过 deadline 后不再继续处理
 */
 func (c *Client) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
-	call := c.Go(serviceMethod, args, reply, make(chan *Call, 1))
+	return c.chainInterceptors(c.call)(ctx, serviceMethod, args, reply)
+}
+
+// call 发送请求并等待响应，是拦截器链最内层的 invoker
+func (c *Client) call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	return c.wait(ctx, c.goContext(ctx, serviceMethod, args, reply, make(chan *Call, 1)))
+}
+
+// wait 等待 call 完成或者 ctx 结束
+func (c *Client) wait(ctx context.Context, call *Call) error {
 	select {
 	case <-ctx.Done():
-		c.removeCall(call.Seq)
-		return errors.New("FastRPC client: call failed: " + ctx.Err().Error())
+		// deadline 已经随请求告知了服务端，服务端会自行停止处理，只有主动取消时才需要通知服务端
+		if c.removeCall(call.Seq) != nil && ctx.Err() == context.Canceled {
+			c.cancelCall(call.Seq)
+		}
+		return conn.Errorf(conn.CodeOf(ctx.Err()), "FastRPC client: call failed: %s", ctx.Err())
 	case call := <-call.Done:
+		if md, ok := metadata.ReplyHolderFromContext(ctx); ok {
+			*md = call.ReplyMetadata
+		}
 		return call.Error
 	}
 }
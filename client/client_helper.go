@@ -1,18 +1,23 @@
 package client
 
 import (
-	"errors"
+	"context"
 	"fastRPC/conn"
+	"fastRPC/metadata"
 	"fmt"
 	"log"
+	"time"
 )
 
 /*
 对一个客户端端来说，接收响应、发送请求是最重要的 2 个功能。
 首先实现接收功能，接收到的响应有三种情况：
 1. call 不存在，可能是请求没有发送完整，或者因为其他原因被取消，但是服务端仍旧处理了。
-2. call 存在，但服务端处理出错，即 h.Error 不为空。
+2. call 存在，但服务端处理出错，即 h.Error 不为空，错误被还原为带有错误码的 *conn.Error。
 3. call 存在，服务端处理正常，那么需要从 body 中读取 Reply 的值。
+此外服务端关闭前会发送 KindGoAway，此后不再发起新的请求，已经发出的请求仍然等待响应。
+服务端主动推送的 KindPush 消息交给 OnPush 注册的处理函数（见 push.go），
+服务端发起的 KindReverseCall 交给 Register 注册的服务（见 reverse.go）。
 */
 func (c *Client) receive() {
 	var err error
@@ -21,34 +26,86 @@ func (c *Client) receive() {
 		if err = c.cliConn.ReadHeader(&h); err != nil {
 			break
 		}
+		if !c.opt.Capabilities.Has(conn.CapMetadata) {
+			// 没有协商 CapMetadata 时忽略对端发来的元数据
+			h.Metadata = nil
+		}
+
+		switch h.Kind {
+		case conn.KindGoAway:
+			c.goAway()
+			err = c.cliConn.ReadBody(nil)
+			continue
+		case conn.KindStreamMsg:
+			err = c.receiveStreamMsg(h.Seq)
+			continue
+		case conn.KindPush:
+			err = c.receivePush(h.ServiceMethod)
+			continue
+		case conn.KindReverseCall:
+			err = c.receiveReverseCall(&h)
+			continue
+		case conn.KindWindowUpdate:
+			if h.Seq == 0 {
+				c.addCredits(int(h.Window))
+				err = c.cliConn.ReadBody(nil)
+			} else {
+				err = c.receiveWindowUpdate(&h)
+			}
+			continue
+		}
 
 		call := c.removeCall(h.Seq)
 		switch {
 		case call == nil:
 			// it usually means that Write partially failed and call was already removed.
 			err = c.cliConn.ReadBody(nil)
-		case h.Error != "":
-			call.Error = fmt.Errorf(h.Error)
+		case h.Err() != nil:
+			call.Error = h.Err()
+			call.ReplyMetadata = h.Metadata
+			err = c.cliConn.ReadBody(nil)
+			call.done()
+		case (call.stream != nil) != (h.Kind == conn.KindStreamEnd):
+			// 普通调用和流式调用用错了方法，例如通过 Call 调用了流式方法
+			call.Error = conn.NewError(conn.Unimplemented, "FastRPC client: stream type mismatch: "+call.ServiceMethod)
+			err = c.cliConn.ReadBody(nil)
+			call.done()
+		case call.stream != nil:
+			// KindStreamEnd, the stream ends without error
+			call.ReplyMetadata = h.Metadata
 			err = c.cliConn.ReadBody(nil)
 			call.done()
 		default:
+			call.ReplyMetadata = h.Metadata
 			err = c.cliConn.ReadBody(call.Reply)
 			if err != nil {
-				call.Error = errors.New("reading body " + err.Error())
+				call.Error = conn.Errorf(conn.Internal, "reading body %s", err)
 			}
 			call.done()
 		}
 	}
 	// error occurs, so terminateCalls all the pending Call
+	// 不是由用户调用 Close 引起的错误，说明连接意外断开，使用 ErrConnLost 便于调用方区分
+	c.mu.Lock()
+	closing := c.closing
+	c.mu.Unlock()
+	if !closing {
+		err = fmt.Errorf("FastRPC client: %w: %v", ErrConnLost, err)
+	}
 	c.terminateCalls(err)
+	c.markDone()
 }
 
 func newClientConn(cliConn conn.Conn, opt *conn.Option) *Client {
 	c := &Client{
-		cliConn: cliConn,
-		opt:     opt,
-		seq:     1, // seq starts with 1, 0 means invalid call
-		pending: make(map[uint64]*Call),
+		cliConn:    cliConn,
+		opt:        opt,
+		seq:        1, // seq starts with 1, 0 means invalid call
+		pending:    make(map[uint64]*Call),
+		done:       make(chan struct{}),
+		flow:       opt.Capabilities.Has(conn.CapFlowControl) && opt.ConnWindow > 0,
+		credits:    int(opt.ConnWindow),
+		creditWake: make(chan struct{}, 1),
 	}
 	go c.receive()
 	return c
@@ -57,7 +114,15 @@ func newClientConn(cliConn conn.Conn, opt *conn.Option) *Client {
 // ===========================================
 
 // client发送请求
-func (c *Client) send(call *Call) {
+// 协商了流量控制时，先等待连接的额度，等待时不持有 mutexSendReq，不影响取消、流消息等控制消息的发送
+// 请求没有发出、call 已经以错误结束时返回该错误
+func (c *Client) send(ctx context.Context, call *Call) error {
+	if err := c.acquireCredit(ctx); err != nil {
+		call.Error = err
+		call.done()
+		return err
+	}
+
 	// make sure that the client will send a complete request
 	c.mutexSendReq.Lock()
 	defer c.mutexSendReq.Unlock()
@@ -65,15 +130,32 @@ func (c *Client) send(call *Call) {
 	// register this call.
 	seq, err := c.registerCall(call)
 	if err != nil {
+		c.addCredits(1)
 		call.Error = err
 		call.done()
-		return
+		return err
 	}
 
 	// prepare request header
 	c.header.ServiceMethod = call.ServiceMethod
 	c.header.Seq = seq
-	c.header.Error = ""
+	c.header.Kind = call.kind
+	c.header.SetError(nil)
+	c.header.Metadata = nil
+	if c.opt.Capabilities.Has(conn.CapMetadata) {
+		c.header.Metadata = call.Metadata
+	}
+	// 将调用方剩余的时间告诉服务端，使用相对时间可以避免两端时钟不一致的问题
+	c.header.Timeout = 0
+	if !call.deadline.IsZero() {
+		if c.header.Timeout = time.Until(call.deadline); c.header.Timeout <= 0 {
+			c.removeCall(seq)
+			c.addCredits(1)
+			call.Error = conn.Errorf(conn.DeadlineExceeded, "FastRPC client: call failed: %s", context.DeadlineExceeded)
+			call.done()
+			return call.Error
+		}
+	}
 
 	// encode and send the request
 	if err := c.cliConn.Write(&c.header, call.Args); err != nil {
@@ -83,14 +165,40 @@ func (c *Client) send(call *Call) {
 		if call != nil {
 			call.Error = err
 			call.done()
+			return err
 		}
 	}
+	return nil
+}
+
+// cancelCall 通知服务端取消 seq 对应的请求，服务端会取消传给服务方法的 ctx
+func (c *Client) cancelCall(seq uint64) {
+	if !c.opt.Capabilities.Has(conn.CapCancellation) || c.NotAvailable() {
+		return
+	}
+	if err := c.writeControl(&conn.Header{Seq: seq, Kind: conn.KindCancel}, nil); err != nil {
+		log.Println("FastRPC client: send cancel error:", err)
+	}
+}
+
+// writeControl 发送请求之外的消息，例如取消、流消息和额度归还
+// 收到 GOAWAY 之后已经开始的流仍然可以继续，因此这里不检查 NotAvailable
+func (c *Client) writeControl(h *conn.Header, body interface{}) error {
+	c.mutexSendReq.Lock()
+	defer c.mutexSendReq.Unlock()
+	return c.cliConn.Write(h, body)
 }
 
 // Go invokes the function asynchronously.
 // It returns the Call structure representing the invocation.
 // Go 是一个异步接口，返回 call 实例
+// 注册了拦截器时，调用在新的协程中经过拦截器链执行。拦截器可能发出零个或多个请求（例如重试），
+// 因此返回的 call 的 Seq 和 Metadata 不对应任何一个请求，始终为零值，ReplyMetadata 是最后一次收到的响应元数据
+// 没有注册拦截器时 Go 不会阻塞：流量控制的额度用完时，返回的 call 以 ResourceExhausted 错误结束，见 flow.go
 func (c *Client) Go(serviceMethod string, args, reply interface{}, done chan *Call) *Call {
+	if !c.hasInterceptors() {
+		return c.goContext(withoutWait(context.Background()), serviceMethod, args, reply, done)
+	}
 	if done == nil {
 		done = make(chan *Call, 10)
 	} else if cap(done) == 0 {
@@ -103,7 +211,36 @@ func (c *Client) Go(serviceMethod string, args, reply interface{}, done chan *Ca
 		Reply:         reply,
 		Done:          done,
 	}
+	go func() {
+		ctx := metadata.WithReplyHolder(context.Background(), &call.ReplyMetadata)
+		call.Error = c.Call(ctx, serviceMethod, args, reply)
+		call.done()
+	}()
+	return call
+}
 
-	c.send(call)
+// goContext 与 Go 相同，ctx 中附加的元数据和 deadline 会随请求一起发送
+func (c *Client) goContext(ctx context.Context, serviceMethod string, args, reply interface{}, done chan *Call) *Call {
+	if done == nil {
+		done = make(chan *Call, 10)
+	} else if cap(done) == 0 {
+		log.Panic("FastRPC client: done channel is unbuffered")
+	}
+
+	call := newCall(ctx, serviceMethod, args, reply, done)
+	c.send(ctx, call)
+	return call
+}
+
+// newCall 创建 Call 实例，ctx 中附加的元数据和 deadline 会随请求一起发送
+func newCall(ctx context.Context, serviceMethod string, args, reply interface{}, done chan *Call) *Call {
+	call := &Call{
+		ServiceMethod: serviceMethod,
+		Args:          args,
+		Reply:         reply,
+		Done:          done,
+	}
+	call.Metadata, _ = metadata.FromOutgoingContext(ctx)
+	call.deadline, _ = ctx.Deadline()
 	return call
 }
@@ -2,13 +2,16 @@ package client
 
 import (
 	"context"
+	"errors"
 	"fastRPC/conn"
+	"fastRPC/metadata"
 	"fastRPC/server"
 	"fmt"
 	"net"
 	"os"
 	"runtime"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -52,6 +55,33 @@ func (b Bar) Timeout(argv int, reply *int) error {
 	return nil
 }
 
+// Bar.Wait 阻塞直到 ctx 被取消，并将取消的原因发送到 canceled
+var canceled = make(chan error, 1)
+
+func (b Bar) Wait(ctx context.Context, argv int, reply *int) error {
+	<-ctx.Done()
+	canceled <- ctx.Err()
+	return ctx.Err()
+}
+
+// Bar.Echo 返回请求元数据中 key 对应的值，并将其设置到响应的元数据中
+func (b Bar) Echo(ctx context.Context, key string, reply *string) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	*reply = md.Get(key)
+	return metadata.SetReply(ctx, metadata.Pairs(key, *reply))
+}
+
+// Bar.Flaky 前 flaky 次调用返回错误
+var flaky int32
+
+func (b Bar) Flaky(argv int, reply *int) error {
+	if atomic.AddInt32(&flaky, -1) >= 0 {
+		return errors.New("try again")
+	}
+	*reply = argv
+	return nil
+}
+
 func startServer(addr chan string) {
 	var b Bar
 	_ = server.Register(&b)
@@ -70,7 +100,8 @@ func TestClient_Call(t *testing.T) {
 
 	t.Run("client timeout", func(t *testing.T) {
 		c, _ := Dial("tcp", addr)
-		ctx, _ := context.WithTimeout(context.Background(), time.Second)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
 		var reply int
 		err := c.Call(ctx, "Bar.Timeout", 1, &reply)
 		_assert(err != nil && strings.Contains(err.Error(), ctx.Err().Error()), "expect a timeout error")
@@ -86,6 +117,152 @@ func TestClient_Call(t *testing.T) {
 	})
 }
 
+func TestClient_Context(t *testing.T) {
+	t.Parallel()
+	var b Bar
+	srv := server.NewServer()
+	_ = srv.Register(&b)
+	l, _ := net.Listen("tcp", ":0")
+	go srv.Accept(l)
+	addr := l.Addr().String()
+
+	t.Run("metadata", func(t *testing.T) {
+		c, _ := Dial("tcp", addr)
+		defer func() { _ = c.Close() }()
+		ctx := metadata.AppendToOutgoingContext(context.Background(), "trace-id", "t1")
+		call := <-c.goContext(ctx, "Bar.Echo", "trace-id", new(string), nil).Done
+		_assert(call.Error == nil && *call.Reply.(*string) == "t1", "expect the request metadata on server side")
+		_assert(call.ReplyMetadata.Get("trace-id") == "t1", "expect the reply metadata, but got %v", call.ReplyMetadata)
+	})
+
+	t.Run("reply metadata", func(t *testing.T) {
+		c, _ := Dial("tcp", addr)
+		defer func() { _ = c.Close() }()
+		// key 不是小写的 MD 也能在服务端通过 Get 读到
+		ctx := metadata.NewOutgoingContext(context.Background(), metadata.MD{"Tenant": "acme"})
+		var md metadata.MD
+		var reply string
+		err := c.Call(metadata.WithReplyHolder(ctx, &md), "Bar.Echo", "tenant", &reply)
+		_assert(err == nil && reply == "acme", "expect the request metadata on server side, but got %q, %v", reply, err)
+		_assert(md.Get("tenant") == "acme", "expect the reply metadata in the holder, but got %v", md)
+	})
+
+	t.Run("handle timeout", func(t *testing.T) {
+		c, _ := Dial("tcp", addr, &conn.Option{HandleTimeout: time.Millisecond * 100})
+		defer func() { _ = c.Close() }()
+		var reply int
+		err := c.Call(context.Background(), "Bar.Wait", 1, &reply)
+		_assert(err != nil && strings.Contains(err.Error(), "handle timeout"), "expect a timeout error")
+		_assert(<-canceled == context.DeadlineExceeded, "expect the handler to observe the timeout")
+	})
+
+	t.Run("client deadline", func(t *testing.T) {
+		c, _ := Dial("tcp", addr)
+		defer func() { _ = c.Close() }()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+		defer cancel()
+		var reply int
+		err := c.Call(ctx, "Bar.Wait", 1, &reply)
+		_assert(err != nil, "expect a timeout error")
+		select {
+		case err := <-canceled:
+			_assert(err == context.DeadlineExceeded, "expect the handler to observe the deadline, but got %v", err)
+		case <-time.After(time.Second):
+			t.Fatal("expect the handler to stop at the client's deadline")
+		}
+	})
+
+	t.Run("expired deadline", func(t *testing.T) {
+		c, _ := Dial("tcp", addr)
+		defer func() { _ = c.Close() }()
+		ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+		defer cancel()
+		call := <-c.goContext(ctx, "Bar.Wait", 1, new(int), nil).Done
+		_assert(conn.CodeOf(call.Error) == conn.DeadlineExceeded, "expect a coded DeadlineExceeded, but got %v", call.Error)
+		_assert(errors.Is(call.Error, context.DeadlineExceeded), "expect errors.Is to match the context error")
+	})
+
+	t.Run("client cancel", func(t *testing.T) {
+		c, _ := Dial("tcp", addr)
+		defer func() { _ = c.Close() }()
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(time.Millisecond*100, cancel)
+		var reply int
+		err := c.Call(ctx, "Bar.Wait", 1, &reply)
+		_assert(err != nil && strings.Contains(err.Error(), context.Canceled.Error()), "expect a canceled error")
+		select {
+		case err := <-canceled:
+			_assert(err == context.Canceled, "expect the handler to be canceled, but got %v", err)
+		case <-time.After(time.Second):
+			t.Fatal("expect the handler to observe the cancellation")
+		}
+		// the connection is still available after canceling
+		err = c.Call(context.Background(), "Bar.Echo", "trace-id", new(string))
+		_assert(err == nil, "failed to call Bar.Echo after canceling: %v", err)
+	})
+
+	t.Run("client disconnect", func(t *testing.T) {
+		c, _ := Dial("tcp", addr)
+		c.Go("Bar.Wait", 1, new(int), nil)
+		time.Sleep(time.Millisecond * 100)
+		_ = c.Close()
+		select {
+		case err := <-canceled:
+			_assert(err == context.Canceled, "expect the handler to be canceled, but got %v", err)
+		case <-time.After(time.Second):
+			t.Fatal("expect the handler to be canceled after the client disconnected")
+		}
+	})
+}
+
+func TestClient_Interceptor(t *testing.T) {
+	t.Parallel()
+	var b Bar
+	srv := server.NewServer()
+	_ = srv.Register(&b)
+	l, _ := net.Listen("tcp", ":0")
+	go srv.Accept(l)
+
+	var trace []string
+	tracing := func(ctx context.Context, serviceMethod string, args, reply interface{}, invoker UnaryInvoker) error {
+		trace = append(trace, "tracing "+serviceMethod)
+		return invoker(metadata.AppendToOutgoingContext(ctx, "trace-id", "t1"), serviceMethod, args, reply)
+	}
+	cache := func(ctx context.Context, serviceMethod string, args, reply interface{}, invoker UnaryInvoker) error {
+		trace = append(trace, "cache "+serviceMethod)
+		if args == "cached" {
+			*reply.(*string) = "hit"
+			return nil
+		}
+		return invoker(ctx, serviceMethod, args, reply)
+	}
+	d := &Dialer{Interceptors: []UnaryClientInterceptor{tracing, cache}}
+	c, err := d.Dial("tcp", l.Addr().String())
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = c.Close() }()
+
+	t.Run("decorate", func(t *testing.T) {
+		trace = nil
+		var reply string
+		err := c.Call(context.Background(), "Bar.Echo", "trace-id", &reply)
+		_assert(err == nil && reply == "t1", "expect the metadata added by interceptor, but got %q, %v", reply, err)
+		_assert(strings.Join(trace, ",") == "tracing Bar.Echo,cache Bar.Echo", "unexpected trace: %v", trace)
+	})
+
+	t.Run("short-circuit", func(t *testing.T) {
+		var reply string
+		err := c.Call(context.Background(), "Bar.Echo", "cached", &reply)
+		_assert(err == nil && reply == "hit", "expect the reply from interceptor, but got %q, %v", reply, err)
+	})
+
+	t.Run("go", func(t *testing.T) {
+		trace = nil
+		call := <-c.Go("Bar.Echo", "trace-id", new(string), nil).Done
+		_assert(call.Error == nil && *call.Reply.(*string) == "t1", "Go should pass through interceptors: %v", call.Error)
+		_assert(len(trace) == 2, "unexpected trace: %v", trace)
+	})
+}
+
 // 使用了 unix 协议创建 socket 连接，适用于本机内部的通信，使用上与 TCP 协议并无区别。
 func TestXDial(t *testing.T) {
 	if runtime.GOOS == "linux" || runtime.GOOS == "darwin" {
@@ -108,3 +285,144 @@ func TestXDial(t *testing.T) {
 		_assert(err == nil, "failed to connect unix socket")
 	}
 }
+
+func TestBackoff_Delay(t *testing.T) {
+	b := Backoff{Initial: time.Second, Max: time.Second * 10, Multiplier: 2, Jitter: 0.1}
+	for retries, expect := range []time.Duration{1, 2, 4, 8, 10, 10} {
+		delay := b.Delay(retries)
+		expect *= time.Second
+		_assert(delay >= expect*9/10 && delay <= expect*11/10, "retries %d: expect about %s, but got %s", retries, expect, delay)
+	}
+}
+
+// TestReconnectClient_CloseWhileDialing 重连的过程中调用 Close，重连得到的连接需要被关闭
+func TestReconnectClient_CloseWhileDialing(t *testing.T) {
+	var b Bar
+	srv := server.NewServer()
+	_ = srv.Register(&b)
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go srv.Accept(l)
+	addr := l.Addr().String()
+
+	d := &Dialer{Backoff: &Backoff{Initial: time.Millisecond}}
+	rc, err := d.DialReconnect("tcp@" + addr)
+	_assert(err == nil, "dial error: %v", err)
+
+	dialing, proceed := make(chan struct{}), make(chan struct{})
+	dialed := make(chan *Client, 1)
+	rc.dial = func() (*Client, error) {
+		close(dialing)
+		<-proceed
+		c, err := Dial("tcp", addr)
+		dialed <- c
+		return c, err
+	}
+	_ = rc.client.Close()
+	<-dialing
+	_ = rc.Close()
+	close(proceed)
+
+	c := <-dialed
+	deadline := time.Now().Add(time.Second)
+	for c.IsAvailable() {
+		if time.Now().After(deadline) {
+			t.Fatal("expect the connection dialed after Close to be closed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	_assert(rc.State() == StateShutdown, "expect SHUTDOWN, but got %s", rc.State())
+}
+
+func TestRetryInterceptor(t *testing.T) {
+	var b Bar
+	srv := server.NewServer()
+	_ = srv.Register(&b)
+	l, _ := net.Listen("tcp", ":0")
+	go srv.Accept(l)
+
+	var attempts int32
+	counting := func(ctx context.Context, serviceMethod string, args, reply interface{}, invoker UnaryInvoker) error {
+		atomic.AddInt32(&attempts, 1)
+		return invoker(ctx, serviceMethod, args, reply)
+	}
+	retryable := func(err error) bool { return strings.Contains(err.Error(), "try again") }
+	d := &Dialer{Interceptors: []UnaryClientInterceptor{
+		RetryInterceptor(RetryPolicies{
+			"Bar.Flaky": {MaxAttempts: 3, Backoff: Backoff{Initial: time.Millisecond * 10}, Retryable: retryable},
+		}),
+		counting,
+	}}
+	c, err := d.Dial("tcp", l.Addr().String())
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = c.Close() }()
+
+	t.Run("retry", func(t *testing.T) {
+		atomic.StoreInt32(&flaky, 2)
+		atomic.StoreInt32(&attempts, 0)
+		var reply int
+		err := c.Call(context.Background(), "Bar.Flaky", 1, &reply)
+		_assert(err == nil && reply == 1, "expect success after retries, but got %v", err)
+		_assert(atomic.LoadInt32(&attempts) == 3, "expect 3 attempts, but got %d", attempts)
+	})
+
+	t.Run("max attempts", func(t *testing.T) {
+		atomic.StoreInt32(&flaky, 5)
+		atomic.StoreInt32(&attempts, 0)
+		err := c.Call(context.Background(), "Bar.Flaky", 1, new(int))
+		_assert(err != nil && atomic.LoadInt32(&attempts) == 3, "expect 3 attempts, but got %d", attempts)
+	})
+
+	// 拦截器附加的元数据随请求发送，返回的 call 中是最后一次响应的元数据
+	t.Run("go", func(t *testing.T) {
+		c, _ := d.Dial("tcp", l.Addr().String())
+		defer func() { _ = c.Close() }()
+		c.Use(func(ctx context.Context, serviceMethod string, args, reply interface{}, invoker UnaryInvoker) error {
+			return invoker(metadata.AppendToOutgoingContext(ctx, "trace-id", "t1"), serviceMethod, args, reply)
+		})
+		call := <-c.Go("Bar.Echo", "trace-id", new(string), nil).Done
+		_assert(call.Error == nil && *call.Reply.(*string) == "t1", "expect the metadata from the interceptor, but got %v", call.Error)
+		_assert(call.ReplyMetadata.Get("trace-id") == "t1", "expect the reply metadata, but got %v", call.ReplyMetadata)
+		_assert(call.Seq == 0, "expect no Seq for an intercepted call")
+	})
+
+	t.Run("not configured", func(t *testing.T) {
+		c, _ := Dial("tcp", l.Addr().String())
+		defer func() { _ = c.Close() }()
+		c.Use(RetryInterceptor(RetryPolicies{"Foo": {MaxAttempts: 3}}), counting)
+		atomic.StoreInt32(&flaky, 1)
+		atomic.StoreInt32(&attempts, 0)
+		err := c.Call(context.Background(), "Bar.Flaky", 1, new(int))
+		_assert(err != nil && atomic.LoadInt32(&attempts) == 1, "expect no retry, but got %d attempts", attempts)
+	})
+
+	// 使用服务级别的策略，等待时间超过了 ctx 的 deadline，不再重试
+	t.Run("deadline", func(t *testing.T) {
+		c, _ := Dial("tcp", l.Addr().String())
+		defer func() { _ = c.Close() }()
+		c.Use(RetryInterceptor(RetryPolicies{
+			"Bar": {MaxAttempts: 3, Backoff: Backoff{Initial: time.Second}, Retryable: retryable},
+		}), counting)
+		atomic.StoreInt32(&flaky, 1)
+		atomic.StoreInt32(&attempts, 0)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*500)
+		defer cancel()
+		err := c.Call(ctx, "Bar.Flaky", 1, new(int))
+		_assert(err != nil && strings.Contains(err.Error(), "try again"), "expect the last error, but got %v", err)
+		_assert(atomic.LoadInt32(&attempts) == 1, "expect 1 attempt, but got %d", attempts)
+	})
+}
+
+// TestClient_OnPushWriteError 订阅消息发送失败时，不保留刚注册的处理函数
+func TestClient_OnPushWriteError(t *testing.T) {
+	p1, p2 := net.Pipe()
+	_ = p2.Close()
+	c := newClientConn(conn.NewGobConn(conn.NewFramer(p1)), &conn.Option{Capabilities: conn.SupportedCapabilities})
+	defer func() { _ = c.Close() }()
+
+	err := c.OnPush("invalidate", func(key string) {})
+	_assert(err != nil, "expect a write error")
+	c.mu.Lock()
+	_, ok := c.pushHandlers["invalidate"]
+	c.mu.Unlock()
+	_assert(!ok, "the handler should be removed after the subscription failed")
+}
@@ -58,6 +58,19 @@ func (c *Client) removeCall(seq uint64) *Call {
 	return call
 }
 
+// goAway 服务端即将关闭，之后的请求直接返回 ErrConnNotAvailable，已经发出的请求不受影响
+func (c *Client) goAway() {
+	c.mu.Lock()
+	c.shutdown = true
+	c.mu.Unlock()
+	c.markDone()
+}
+
+// markDone 关闭 done，通知 ReconnectClient 等使用者 client 已经不可用
+func (c *Client) markDone() {
+	c.doneOnce.Do(func() { close(c.done) })
+}
+
 // terminateCalls
 // 服务端或客户端发生错误时调用，将 shutdown 设置为 true，且将错误信息通知所有 pending 状态的 call
 func (c *Client) terminateCalls(err error) {
@@ -0,0 +1,77 @@
+package client
+
+import (
+	"context"
+	"fastRPC/conn"
+)
+
+/*
+连接级流量控制
+协商了 CapFlowControl 时，服务端在回复的 Option.ConnWindow 中告知窗口大小，即同时处理的最大请求数。
+每发送一个请求（包括流式调用）消耗一个额度，额度用完后 send 阻塞，直到服务端通过 Seq 为 0 的
+KindWindowUpdate 归还额度、ctx 结束或者连接不可用。因此未完成的请求数（pending）不会超过窗口大小。
+Go 是异步接口，不会等待额度：额度用完时立即以 ResourceExhausted 错误结束返回的 call，由调用方决定是否稍后重试。
+注册了拦截器时 Go 在新的协程中执行 Call，此时与 Call 相同，在该协程中等待额度。
+*/
+
+var errWindowExhausted = conn.NewError(conn.ResourceExhausted, "FastRPC client: flow control window is exhausted")
+
+type noWaitKey struct{}
+
+// withoutWait 额度用完时 acquireCredit 立即返回 errWindowExhausted，而不是等待
+func withoutWait(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noWaitKey{}, true)
+}
+
+// acquireCredit 等待并消耗一个额度
+func (c *Client) acquireCredit(ctx context.Context) error {
+	if !c.flow {
+		return nil
+	}
+	for {
+		c.mu.Lock()
+		if c.shutdown || c.closing {
+			c.mu.Unlock()
+			return ErrConnNotAvailable
+		}
+		if c.credits > 0 {
+			c.credits--
+			more := c.credits > 0
+			c.mu.Unlock()
+			if more {
+				// creditWake 只能唤醒一个等待者，还有剩余额度时继续唤醒下一个
+				c.wakeCredit()
+			}
+			return nil
+		}
+		c.mu.Unlock()
+		if ctx.Value(noWaitKey{}) != nil {
+			return errWindowExhausted
+		}
+
+		select {
+		case <-c.creditWake:
+		case <-c.done:
+		case <-ctx.Done():
+			return conn.Errorf(conn.CodeOf(ctx.Err()), "FastRPC client: waiting for flow control window: %s", ctx.Err())
+		}
+	}
+}
+
+// addCredits 服务端归还了 n 个额度，或者请求没有发出，额度退还
+func (c *Client) addCredits(n int) {
+	if !c.flow {
+		return
+	}
+	c.mu.Lock()
+	c.credits += n
+	c.mu.Unlock()
+	c.wakeCredit()
+}
+
+func (c *Client) wakeCredit() {
+	select {
+	case c.creditWake <- struct{}{}:
+	default:
+	}
+}
@@ -2,6 +2,7 @@ package client
 
 import (
 	"bufio"
+	"crypto/tls"
 	"errors"
 	"fastRPC/conn"
 	"fastRPC/html_rpc"
@@ -39,8 +40,14 @@ func DialHTTP(network, address string, opts ...*conn.Option) (*Client, error) {
 
 // XDial calls different functions to connect to an RPC server according the first parameter rpcAddr.
 // rpcAddr is a general format (protocol@addr) to represent a rpc server
-// eg, http@10.0.0.1:7001, tcp@10.0.0.1:9999, unix@/tmp/fastrpc.sock
+// eg, http@10.0.0.1:7001, tcp@10.0.0.1:9999, tls@10.0.0.1:9999, unix@/tmp/fastrpc.sock
+// tls@ 使用系统默认的根证书校验服务端，需要自定义证书时使用 Dialer.XDial
 func XDial(rpcAddr string, opts ...*conn.Option) (*Client, error) {
+	return xDial(rpcAddr, nil, opts...)
+}
+
+// xDial 与 XDial 相同，tls@ 使用 tlsConfig
+func xDial(rpcAddr string, tlsConfig *tls.Config, opts ...*conn.Option) (*Client, error) {
 	parts := strings.Split(rpcAddr, "@")
 	if len(parts) != 2 {
 		return nil, fmt.Errorf("FastRPC client err: wrong format '%s', expect protocol@addr", rpcAddr)
@@ -50,6 +57,8 @@ func XDial(rpcAddr string, opts ...*conn.Option) (*Client, error) {
 	switch protocol {
 	case "http":
 		return DialHTTP("tcp", addr, opts...)
+	case "tls":
+		return DialTLS("tcp", addr, tlsConfig, opts...)
 	default:
 		// tcp, unix or other transport protocol
 		return Dial(protocol, addr, opts...)
@@ -0,0 +1,95 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"fastRPC/conn"
+)
+
+/*
+客户端拦截器（中间件）
+链路追踪、重试、日志、耗时统计等逻辑通过拦截器统一包装每一次调用。
+拦截器按照注册的顺序嵌套，先注册的在外层，拦截器可以修改 ctx（例如附加元数据），
+也可以不调用 invoker 直接返回结果：
+
+	c.Use(tracing, logging)
+	tracing -> logging -> send request
+*/
+
+// UnaryInvoker 发起调用，拦截器需要调用 invoker 才能继续执行后续的拦截器并真正发出请求
+type UnaryInvoker func(ctx context.Context, serviceMethod string, args, reply interface{}) error
+
+// UnaryClientInterceptor 拦截器，可以在 invoker 前后增加逻辑
+type UnaryClientInterceptor func(ctx context.Context, serviceMethod string, args, reply interface{}, invoker UnaryInvoker) error
+
+// Use 注册拦截器，拦截器对之后发起的所有调用生效
+func (c *Client) Use(interceptors ...UnaryClientInterceptor) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.interceptors = append(c.interceptors, interceptors...)
+}
+
+func (c *Client) hasInterceptors() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.interceptors) > 0
+}
+
+func (c *Client) chainInterceptors(invoker UnaryInvoker) UnaryInvoker {
+	c.mu.Lock()
+	interceptors := c.interceptors
+	c.mu.Unlock()
+	return chainInterceptors(interceptors, invoker)
+}
+
+// chainInterceptors 将拦截器和 invoker 组合为一个 invoker，interceptors[0] 在最外层
+func chainInterceptors(interceptors []UnaryClientInterceptor, invoker UnaryInvoker) UnaryInvoker {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], invoker
+		invoker = func(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+			return interceptor(ctx, serviceMethod, args, reply, next)
+		}
+	}
+	return invoker
+}
+
+// ============================================================
+
+// Dialer 包含建立连接时的选项，通过 Dialer 创建的 Client 会预先注册 Interceptors
+// 零值的 Dialer 与包级别的 Dial、DialHTTP、XDial 函数等价
+type Dialer struct {
+	Interceptors []UnaryClientInterceptor
+	TLSConfig    *tls.Config // XDial 连接 tls@ 地址时使用，见 tls.go
+
+	// 以下选项只对 DialReconnect 生效，见 reconnect.go
+	Backoff       *Backoff              // 重连的退避策略，nil 表示使用 DefaultBackoff
+	OnStateChange func(state ConnState) // 连接状态发生变化时调用
+}
+
+func (d *Dialer) init(c *Client, err error) (*Client, error) {
+	if err != nil {
+		return nil, err
+	}
+	c.Use(d.Interceptors...)
+	return c, nil
+}
+
+// Dial connects to an RPC server at the specified network address.
+func (d *Dialer) Dial(network, address string, opts ...*conn.Option) (*Client, error) {
+	return d.init(Dial(network, address, opts...))
+}
+
+// DialTLS connects to an RPC server at the specified network address over TLS.
+func (d *Dialer) DialTLS(network, address string, config *tls.Config, opts ...*conn.Option) (*Client, error) {
+	return d.init(DialTLS(network, address, config, opts...))
+}
+
+// DialHTTP connects to an HTTP RPC server at the specified network address.
+func (d *Dialer) DialHTTP(network, address string, opts ...*conn.Option) (*Client, error) {
+	return d.init(DialHTTP(network, address, opts...))
+}
+
+// XDial connects to an RPC server according to rpcAddr (protocol@addr).
+func (d *Dialer) XDial(rpcAddr string, opts ...*conn.Option) (*Client, error) {
+	return d.init(xDial(rpcAddr, d.TLSConfig, opts...))
+}
@@ -0,0 +1,48 @@
+package client
+
+import (
+	"context"
+	"fastRPC/conn"
+	"fastRPC/metadata"
+	"time"
+)
+
+/*
+单向调用
+Notify 发送一个 KindOneWay 请求后立即返回，不注册 Call，也不等待响应，服务端处理完成后不回复。
+返回的错误只表示请求没有发送成功，服务方法的返回值和错误都无从得知：
+
+	err := c.Notify(ctx, "Audit.Log", &Event{...})
+
+ctx 中的元数据和 deadline 与 Call 一样随请求发送，但请求发出之后无法再取消。
+协商了流量控制时，单向调用同样占用连接的额度。Notify 不经过 UnaryClientInterceptor。
+*/
+
+// Notify invokes the named function without waiting for it, the server sends no response.
+func (c *Client) Notify(ctx context.Context, serviceMethod string, args interface{}) error {
+	if !c.opt.Capabilities.Has(conn.CapOneWay) {
+		return conn.NewError(conn.Unimplemented, "FastRPC client: one-way call is not supported by the server")
+	}
+	if err := c.acquireCredit(ctx); err != nil {
+		return err
+	}
+
+	h := &conn.Header{ServiceMethod: serviceMethod, Kind: conn.KindOneWay}
+	if c.opt.Capabilities.Has(conn.CapMetadata) {
+		h.Metadata, _ = metadata.FromOutgoingContext(ctx)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if h.Timeout = time.Until(deadline); h.Timeout <= 0 {
+			c.addCredits(1)
+			return conn.Errorf(conn.DeadlineExceeded, "FastRPC client: notify failed: %s", context.DeadlineExceeded)
+		}
+	}
+
+	c.mutexSendReq.Lock()
+	defer c.mutexSendReq.Unlock()
+	if c.NotAvailable() {
+		c.addCredits(1)
+		return ErrConnNotAvailable
+	}
+	return c.cliConn.Write(h, args)
+}
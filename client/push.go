@@ -0,0 +1,111 @@
+package client
+
+import (
+	"fastRPC/conn"
+	"log"
+	"reflect"
+)
+
+/*
+服务端推送
+OnPush 注册 topic 主题的处理函数，并通知服务端订阅该主题。处理函数的形式为 func(T) 或 func(*T)，
+推送的消息由 receive 协程按照 T 解码后调用处理函数：
+
+	_ = c.OnPush("cache", func(key string) {
+		cache.Delete(key)
+	})
+
+处理函数在 receive 协程中依次调用，消息的顺序与服务端发送的顺序相同，但处理函数不能阻塞，
+否则会阻塞该连接上所有响应的接收，耗时的处理需要交给其他协程。
+每个主题只有一个处理函数，重复注册时替换原来的处理函数，handler 为 nil 时取消订阅。
+主题不能为空，每个连接最多订阅 conn.MaxTopics 个主题。
+连接断开后订阅随之失效，新的连接需要重新注册。
+*/
+
+// pushHandler 一个主题的处理函数
+type pushHandler struct {
+	fn    reflect.Value
+	typ   reflect.Type // type of message, i.e. the parameter type of fn without pointer
+	isPtr bool         // fn accepts *T
+}
+
+// OnPush registers handler for the messages pushed by the server on topic, handler is a func(T) or func(*T).
+func (c *Client) OnPush(topic string, handler interface{}) error {
+	if !c.opt.Capabilities.Has(conn.CapPush) {
+		return conn.NewError(conn.Unimplemented, "FastRPC client: push is not supported by the server")
+	}
+	if handler == nil {
+		c.mu.Lock()
+		_, ok := c.pushHandlers[topic]
+		delete(c.pushHandlers, topic)
+		c.mu.Unlock()
+		if !ok {
+			return nil
+		}
+		return c.writeControl(&conn.Header{ServiceMethod: topic, Kind: conn.KindUnsubscribe}, nil)
+	}
+
+	if topic == "" {
+		return conn.NewError(conn.InvalidArgument, "FastRPC client: push topic must not be empty")
+	}
+	fn := reflect.ValueOf(handler)
+	if fn.Kind() != reflect.Func || fn.Type().NumIn() != 1 || fn.Type().NumOut() != 0 {
+		return conn.Errorf(conn.InvalidArgument, "FastRPC client: push handler must be a func(T), but got %T", handler)
+	}
+	h := &pushHandler{fn: fn, typ: fn.Type().In(0)}
+	if h.typ.Kind() == reflect.Ptr {
+		h.typ, h.isPtr = h.typ.Elem(), true
+	}
+
+	c.mu.Lock()
+	if c.pushHandlers == nil {
+		c.pushHandlers = make(map[string]*pushHandler)
+	}
+	_, subscribed := c.pushHandlers[topic]
+	if !subscribed && len(c.pushHandlers) >= conn.MaxTopics {
+		c.mu.Unlock()
+		return conn.Errorf(conn.ResourceExhausted, "FastRPC client: too many topics subscribed, at most %d", conn.MaxTopics)
+	}
+	c.pushHandlers[topic] = h
+	c.mu.Unlock()
+	if subscribed {
+		return nil
+	}
+	if err := c.writeControl(&conn.Header{ServiceMethod: topic, Kind: conn.KindSubscribe}, nil); err != nil {
+		// 服务端没有收到订阅，撤销刚注册的处理函数，期间被其他调用替换的除外
+		c.mu.Lock()
+		if c.pushHandlers[topic] == h {
+			delete(c.pushHandlers, topic)
+		}
+		c.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// receivePush 读取一条推送消息，交给主题的处理函数，没有处理函数时丢弃
+func (c *Client) receivePush(topic string) error {
+	c.mu.Lock()
+	h := c.pushHandlers[topic]
+	c.mu.Unlock()
+	if h == nil {
+		return c.cliConn.ReadBody(nil)
+	}
+
+	v := reflect.New(h.typ)
+	if err := c.cliConn.ReadBody(v.Interface()); err != nil {
+		return err
+	}
+	if !h.isPtr {
+		v = v.Elem()
+	}
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("FastRPC client: push handler of %s panic: %v", topic, r)
+			}
+		}()
+		h.fn.Call([]reflect.Value{v})
+	}()
+	return nil
+}
@@ -0,0 +1,331 @@
+package client
+
+import (
+	"context"
+	"fastRPC/conn"
+	"fastRPC/metadata"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+/*
+自动重连的客户端
+Client 的连接断开之后就永久不可用了。ReconnectClient 在连接断开（或收到服务端的 GOAWAY）之后，
+按照指数退避加随机抖动的间隔重新连接原来的地址，重连成功后新的调用自动使用新的连接：
+1. 连接断开时未完成的请求返回 ErrConnLost（使用 errors.Is 判断），是否重试由调用方决定；
+2. 重连期间 Call 等待连接恢复，直到 ctx 结束；Go 不等待，直接返回 ErrConnNotAvailable；
+3. 连接状态的变化通过 Dialer.OnStateChange 通知调用方。
+
+	d := &client.Dialer{OnStateChange: func(s client.ConnState) { log.Println("state:", s) }}
+	rc, err := d.DialReconnect("tcp@10.0.0.1:9999")
+*/
+
+// ConnState 连接状态
+type ConnState int
+
+const (
+	StateIdle             ConnState = iota // 尚未连接
+	StateConnecting                        // 正在连接
+	StateReady                             // 连接可用
+	StateTransientFailure                  // 连接失败，等待下一次重连
+	StateShutdown                          // 用户调用了 Close
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case StateIdle:
+		return "IDLE"
+	case StateConnecting:
+		return "CONNECTING"
+	case StateReady:
+		return "READY"
+	case StateTransientFailure:
+		return "TRANSIENT_FAILURE"
+	case StateShutdown:
+		return "SHUTDOWN"
+	}
+	return "UNKNOWN"
+}
+
+// Backoff 指数退避策略，第 n 次重连前等待 min(Initial * Multiplier^n, Max)，并随机浮动 ±Jitter 的比例
+// Max 为 0 表示不限制，Multiplier 小于 1 时按 1 处理，即固定等待 Initial
+type Backoff struct {
+	Initial    time.Duration
+	Max        time.Duration
+	Multiplier float64
+	Jitter     float64
+}
+
+var DefaultBackoff = Backoff{
+	Initial:    time.Millisecond * 100,
+	Max:        time.Second * 10,
+	Multiplier: 1.6,
+	Jitter:     0.2,
+}
+
+// Delay 返回第 retries 次（从 0 开始）重试之前需要等待的时间
+func (b Backoff) Delay(retries int) time.Duration {
+	delay, max := float64(b.Initial), float64(b.Max)
+	for i := 0; i < retries && b.Multiplier > 1 && (max == 0 || delay < max); i++ {
+		delay *= b.Multiplier
+	}
+	if max > 0 && delay > max {
+		delay = max
+	}
+	delay *= 1 + b.Jitter*(rand.Float64()*2-1)
+	if delay < 0 {
+		return 0
+	}
+	return time.Duration(delay)
+}
+
+// ============================================================
+
+// ReconnectClient 在连接断开后自动重连的客户端，可以被多个协程同时使用
+type ReconnectClient struct {
+	dial          func() (*Client, error)
+	backoff       Backoff
+	onStateChange func(state ConnState)
+
+	mu           sync.Mutex // protect following
+	client       *Client
+	state        ConnState
+	ready        chan struct{} // closed when the state becomes StateReady or StateShutdown
+	interceptors []UnaryClientInterceptor
+	closing      chan struct{}
+}
+
+// DialReconnect connects to an RPC server according to rpcAddr (protocol@addr),
+// and reconnects to it whenever the connection is lost.
+// 第一次连接失败时直接返回错误
+func (d *Dialer) DialReconnect(rpcAddr string, opts ...*conn.Option) (*ReconnectClient, error) {
+	rc := &ReconnectClient{
+		backoff:       DefaultBackoff,
+		onStateChange: d.OnStateChange,
+		ready:         make(chan struct{}),
+		closing:       make(chan struct{}),
+		interceptors:  append([]UnaryClientInterceptor(nil), d.Interceptors...),
+	}
+	if d.Backoff != nil {
+		rc.backoff = *d.Backoff
+	}
+	// 拦截器注册在 ReconnectClient 上而不是每个连接上，这样重试等拦截器可以等待重连之后再次调用
+	rc.dial = func() (*Client, error) {
+		return xDial(rpcAddr, d.TLSConfig, opts...)
+	}
+
+	rc.setState(StateConnecting, nil)
+	c, err := rc.dial()
+	if err != nil {
+		return nil, err
+	}
+	rc.setState(StateReady, c)
+	go rc.watch(c)
+	return rc, nil
+}
+
+// setState 更新连接状态并通知调用方，c 不为 nil 时替换当前的 Client
+// 用户已经调用了 Close 时，c 不会再被使用，直接关闭，避免重连得到的连接泄漏
+func (rc *ReconnectClient) setState(state ConnState, c *Client) {
+	rc.mu.Lock()
+	if c != nil && rc.closed() {
+		rc.mu.Unlock()
+		_ = c.Close()
+		return
+	}
+	if rc.state == StateShutdown || (rc.state == state && c == nil) {
+		rc.mu.Unlock()
+		return
+	}
+	rc.state = state
+	if c != nil {
+		rc.client = c
+	}
+	select {
+	case <-rc.ready:
+		// ready 在上一次连接可用时已经被关闭，需要新的 ready 供 Call 等待
+		if state != StateReady && state != StateShutdown {
+			rc.ready = make(chan struct{})
+		}
+	default:
+		if state == StateReady || state == StateShutdown {
+			close(rc.ready)
+		}
+	}
+	onStateChange := rc.onStateChange
+	rc.mu.Unlock()
+
+	if onStateChange != nil {
+		onStateChange(state)
+	}
+}
+
+// watch 等待当前的 Client 不可用，然后按照退避策略重连，直到重连成功或者用户调用 Close
+func (rc *ReconnectClient) watch(c *Client) {
+	for {
+		select {
+		case <-c.done:
+		case <-rc.closing:
+			return
+		}
+
+		// 退避等待期间处于 StateTransientFailure，真正开始连接时才变为 StateConnecting
+		rc.setState(StateTransientFailure, nil)
+		for retries := 0; ; retries++ {
+			select {
+			case <-time.After(rc.backoff.Delay(retries)):
+			case <-rc.closing:
+				return
+			}
+			rc.setState(StateConnecting, nil)
+			next, err := rc.dial()
+			if err == nil {
+				c = next
+				break
+			}
+			log.Println("FastRPC client: reconnect error:", err)
+			rc.setState(StateTransientFailure, nil)
+		}
+		rc.setState(StateReady, c)
+	}
+}
+
+// closed 用户是否已经调用了 Close，调用方需要持有 rc.mu
+func (rc *ReconnectClient) closed() bool {
+	select {
+	case <-rc.closing:
+		return true
+	default:
+		return false
+	}
+}
+
+// State 返回当前的连接状态
+func (rc *ReconnectClient) State() ConnState {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.state
+}
+
+// available 返回当前可用的 Client，不等待重连
+func (rc *ReconnectClient) available() (*Client, error) {
+	rc.mu.Lock()
+	state, c := rc.state, rc.client
+	rc.mu.Unlock()
+
+	switch {
+	case state == StateShutdown:
+		return nil, ErrConnClosed
+	case state == StateReady && c.IsAvailable():
+		return c, nil
+	}
+	return nil, ErrConnNotAvailable
+}
+
+// current 等待连接可用，返回当前的 Client
+func (rc *ReconnectClient) current(ctx context.Context) (*Client, error) {
+	for {
+		c, err := rc.available()
+		if err != ErrConnNotAvailable {
+			return c, err
+		}
+
+		rc.mu.Lock()
+		state, c, ready := rc.state, rc.client, rc.ready
+		rc.mu.Unlock()
+
+		// 连接已经断开但 watch 还没有开始重连时，等待 client 的 done 之后状态会变为 StateTransientFailure
+		select {
+		case <-ready:
+			if state == StateReady {
+				select {
+				case <-c.done:
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				}
+			}
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Call invokes the named function, waits for it to complete, and returns its error status.
+// 重连期间 Call 等待连接恢复，直到 ctx 结束
+func (rc *ReconnectClient) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	rc.mu.Lock()
+	interceptors := rc.interceptors
+	rc.mu.Unlock()
+	return chainInterceptors(interceptors, rc.call)(ctx, serviceMethod, args, reply)
+}
+
+// call 等待连接可用后发起调用，是拦截器链最内层的 invoker
+func (rc *ReconnectClient) call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	c, err := rc.current(ctx)
+	if err != nil {
+		return err
+	}
+	return c.Call(ctx, serviceMethod, args, reply)
+}
+
+// Go invokes the function asynchronously.
+// 没有注册拦截器时，重连期间直接返回 ErrConnNotAvailable；注册了拦截器时与 Client.Go 相同，在新的协程中执行 Call，
+// 返回的 call 的 Seq 和 Metadata 为零值
+func (rc *ReconnectClient) Go(serviceMethod string, args, reply interface{}, done chan *Call) *Call {
+	if done == nil {
+		done = make(chan *Call, 10)
+	} else if cap(done) == 0 {
+		log.Panic("FastRPC client: done channel is unbuffered")
+	}
+	call := &Call{ServiceMethod: serviceMethod, Args: args, Reply: reply, Done: done}
+
+	rc.mu.Lock()
+	intercepted := len(rc.interceptors) > 0
+	rc.mu.Unlock()
+	if intercepted {
+		go func() {
+			ctx := metadata.WithReplyHolder(context.Background(), &call.ReplyMetadata)
+			call.Error = rc.Call(ctx, serviceMethod, args, reply)
+			call.done()
+		}()
+		return call
+	}
+
+	c, err := rc.available()
+	if err != nil {
+		call.Error = err
+		call.done()
+		return call
+	}
+	return c.Go(serviceMethod, args, reply, done)
+}
+
+// Use 注册拦截器，拦截器包装的是整个调用，重连前后都生效
+func (rc *ReconnectClient) Use(interceptors ...UnaryClientInterceptor) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.interceptors = append(rc.interceptors, interceptors...)
+}
+
+// IsAvailable return true while the current connection is available
+func (rc *ReconnectClient) IsAvailable() bool {
+	_, err := rc.available()
+	return err == nil
+}
+
+// Close stops reconnecting and closes the current connection.
+func (rc *ReconnectClient) Close() error {
+	rc.mu.Lock()
+	if rc.closed() {
+		rc.mu.Unlock()
+		return ErrConnClosed
+	}
+	close(rc.closing)
+	c := rc.client
+	rc.mu.Unlock()
+
+	rc.setState(StateShutdown, nil)
+	return c.Close()
+}
@@ -0,0 +1,92 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fastRPC/conn"
+	"net"
+	"strings"
+	"time"
+)
+
+/*
+重试策略
+并不是所有的方法都是幂等的，因此重试需要按方法显式开启：RetryPolicies 的 key 为 "Service.Method"，
+也可以是 "Service" 表示该服务的所有方法，"Service.Method" 优先。没有配置策略的方法不会重试。
+
+	c.Use(client.RetryInterceptor(client.RetryPolicies{
+		"Foo.Get": {MaxAttempts: 3, Backoff: client.DefaultBackoff},
+	}))
+
+重试不会超过调用方 ctx 的 deadline：如果等待下一次重试之前 ctx 就会结束，直接返回最后一次的错误。
+XClient 使用同样的策略，并且每次重试都会通过 Discovery 重新选择服务实例。
+*/
+
+// RetryPolicy 一个方法的重试策略
+type RetryPolicy struct {
+	MaxAttempts int                  // 最多调用的次数（包括第一次），小于 2 表示不重试
+	Backoff     Backoff              // 两次调用之间的等待时间
+	Retryable   func(err error) bool // 判断错误是否可以重试，nil 表示使用 IsRetryable
+}
+
+// RetryPolicies 按方法配置的重试策略
+type RetryPolicies map[string]*RetryPolicy
+
+// Lookup 返回 serviceMethod 对应的重试策略，没有配置时返回 nil
+func (ps RetryPolicies) Lookup(serviceMethod string) *RetryPolicy {
+	if p, ok := ps[serviceMethod]; ok {
+		return p
+	}
+	if dot := strings.LastIndex(serviceMethod, "."); dot >= 0 {
+		return ps[serviceMethod[:dot]]
+	}
+	return nil
+}
+
+// IsRetryable 默认的可重试错误：连接断开、连接不可用、网络错误以及服务端返回的 Unavailable，
+// 这些情况下请求没有被服务端处理或者处理结果已经丢失
+func IsRetryable(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, ErrConnLost) || errors.Is(err, ErrConnNotAvailable) || errors.As(err, &netErr) ||
+		conn.CodeOf(err) == conn.Unavailable
+}
+
+// Do 按照策略调用 f，直到成功、错误不可重试、次数用完或者 ctx 结束，attempt 从 0 开始
+func (p *RetryPolicy) Do(ctx context.Context, f func(attempt int) error) error {
+	retryable := p.Retryable
+	if retryable == nil {
+		retryable = IsRetryable
+	}
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		if err = f(attempt); err == nil || attempt+1 >= p.MaxAttempts || !retryable(err) {
+			return err
+		}
+
+		delay := p.Backoff.Delay(attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= delay {
+			return err
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+	}
+}
+
+// RetryInterceptor 返回按照 policies 重试的拦截器，应当注册在最外层，使每次重试都经过其余的拦截器
+func RetryInterceptor(policies RetryPolicies) UnaryClientInterceptor {
+	return func(ctx context.Context, serviceMethod string, args, reply interface{}, invoker UnaryInvoker) error {
+		p := policies.Lookup(serviceMethod)
+		if p == nil {
+			return invoker(ctx, serviceMethod, args, reply)
+		}
+		return p.Do(ctx, func(int) error {
+			return invoker(ctx, serviceMethod, args, reply)
+		})
+	}
+}
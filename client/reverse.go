@@ -0,0 +1,121 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fastRPC/conn"
+	"fastRPC/metadata"
+	"fastRPC/service"
+	"log"
+	"reflect"
+	"strings"
+)
+
+/*
+反向调用
+Register 在客户端注册服务，服务端通过 server.Peer 在同一个连接上调用（格式见 server/reverse.go）。
+服务的形式与服务端注册的服务相同，由 service.NewService 解析，但只支持普通方法，不支持流式方法：
+
+	c, _ := client.Dial("tcp", addr)
+	_ = c.Register(&Agent{})
+	_ = c.Call(ctx, "Registry.Hello", agentID, &ok)
+
+请求的参数由 receive 协程解码，服务方法在新的协程中执行，完成后发送 KindReverseReply。
+传给服务方法的 ctx 带有服务端的元数据和 deadline，客户端关闭时被取消。
+*/
+
+// Register publishes the receiver's methods for the server to call over this connection.
+func (c *Client) Register(rcvr interface{}) error {
+	if !c.opt.Capabilities.Has(conn.CapReverse) {
+		return conn.NewError(conn.Unimplemented, "FastRPC client: reverse call is not supported by the server")
+	}
+	s := service.NewService(rcvr)
+	if _, dup := c.services.LoadOrStore(s.GetName(), s); dup {
+		return errors.New("FastRPC client: service already defined: " + s.GetName())
+	}
+	return nil
+}
+
+// findService 查找客户端注册的服务方法
+func (c *Client) findService(serviceMethod string) (*service.Service, *service.MethodType, error) {
+	dot := strings.LastIndex(serviceMethod, ".")
+	if dot < 0 {
+		return nil, nil, conn.NewError(conn.InvalidArgument, "FastRPC client: service/method request ill-formed: "+serviceMethod)
+	}
+	svci, ok := c.services.Load(serviceMethod[:dot])
+	if !ok {
+		return nil, nil, conn.NewError(conn.NotFound, "FastRPC client: can't find service: "+serviceMethod[:dot])
+	}
+	svc := svci.(*service.Service)
+	mType := svc.GetMethod(serviceMethod[dot+1:])
+	if mType == nil {
+		return nil, nil, conn.NewError(conn.NotFound, "FastRPC client: can't find method: "+serviceMethod[dot+1:])
+	}
+	if mType.StreamType() != service.Unary {
+		return nil, nil, conn.NewError(conn.Unimplemented, "FastRPC client: streaming method can't be called in reverse: "+serviceMethod)
+	}
+	return svc, mType, nil
+}
+
+// receiveReverseCall 读取服务端的反向调用，在新的协程中执行
+func (c *Client) receiveReverseCall(h *conn.Header) error {
+	svc, mType, err := c.findService(h.ServiceMethod)
+	if err != nil {
+		go c.replyReverseCall(h.Seq, nil, err)
+		return c.cliConn.ReadBody(nil)
+	}
+
+	argv, replyv := mType.NewArgv(), mType.NewReplyv()
+	argvi := argv.Interface()
+	if argv.Type().Kind() != reflect.Ptr {
+		argvi = argv.Addr().Interface()
+	}
+	if err := c.cliConn.ReadBody(argvi); err != nil {
+		go c.replyReverseCall(h.Seq, nil, conn.Errorf(conn.InvalidArgument, "FastRPC client: read body error: %v", err))
+		return err
+	}
+
+	go func() {
+		ctx, cancel := c.reverseContext(h)
+		defer cancel()
+		err := svc.CallContext(ctx, mType, argv, replyv)
+		var perr *service.PanicError
+		if errors.As(err, &perr) {
+			err = conn.NewError(conn.Internal, perr.Error())
+		}
+		c.replyReverseCall(h.Seq, replyv.Interface(), err)
+	}()
+	return nil
+}
+
+// reverseContext 反向调用的 ctx，带有服务端的元数据和 deadline，客户端不可用时被取消
+func (c *Client) reverseContext(h *conn.Header) (context.Context, context.CancelFunc) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	ctx = metadata.NewIncomingContext(context.Background(), h.Metadata)
+	if h.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, h.Timeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	go func() {
+		select {
+		case <-c.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// replyReverseCall 发送反向调用的响应
+func (c *Client) replyReverseCall(seq uint64, reply interface{}, err error) {
+	h := &conn.Header{Seq: seq, Kind: conn.KindReverseReply}
+	h.SetError(err)
+	if err != nil {
+		reply = nil
+	}
+	if err := c.writeControl(h, reply); err != nil {
+		log.Println("FastRPC client: send reverse reply error:", err)
+	}
+}
@@ -0,0 +1,316 @@
+package client
+
+import (
+	"context"
+	"fastRPC/conn"
+	"fastRPC/metadata"
+	"io"
+	"log"
+	"reflect"
+	"sync"
+)
+
+/*
+流式调用
+服务端流式调用
+CallStream 发送请求后立即返回 *Stream，服务端发送的消息由 receive 协程解码后放入 Stream 的队列，
+调用方通过 Recv 逐条读取，流结束时 Recv 返回 io.EOF，服务方法返回错误时 Recv 返回该错误：
+
+	stream, err := c.CallStream(ctx, "Export.Rows", &Args{}, new(Row))
+	for {
+		var row Row
+		if err := stream.Recv(&row); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+
+由于消息在到达时就需要解码，CallStream 的 reply 参数用来确定消息的类型（reply 必须是指针），Recv 也只能接收同样类型的指针。
+ctx 结束时流被取消，已经收到的消息仍然可以读取。流式调用不经过 UnaryClientInterceptor。
+
+客户端流式方法和双向流通过 NewStream 发起，请求本身没有参数，之后通过 Send 逐条发送消息，
+CloseSend 表示不再发送（半关闭）。客户端流式方法的返回值通过 CloseAndRecv 读取：
+
+	stream, err := c.NewStream(ctx, "Import.Rows", new(int))
+	for _, row := range rows {
+		if err := stream.Send(row); err != nil {
+			break
+		}
+	}
+	var total int
+	err = stream.CloseAndRecv(&total)
+
+流量控制：每个方向最多有 conn.StreamWindow 条未被对方消费的消息，额度用完时 Send 阻塞，
+Recv 每读取一半窗口的消息就通过 KindWindowUpdate 归还额度。
+*/
+
+// Stream 客户端一侧的流
+type Stream struct {
+	c    *Client
+	call *Call
+	typ  reflect.Type // type of message, i.e. the type reply points to
+
+	mu     sync.Mutex      // protect following
+	queue  []reflect.Value // messages received but not read by Recv
+	notify chan struct{}   // a message is pushed into queue
+	ended  chan struct{}   // closed when the stream ends
+	err    error           // the final status, io.EOF means the stream ends without error
+
+	credits  int           // messages that Send can still send before the server grants more
+	wake     chan struct{} // credits is increased
+	consumed int           // messages read by Recv but not yet granted back to the server
+
+	sendMu     sync.Mutex // serialize Send and CloseSend
+	sendClosed bool       // CloseSend has been called
+	startErr   error      // the request was not sent, Send and CloseSend must not write frames for it
+}
+
+// CallStream invokes a server-streaming method, reply is a pointer to determine the type of messages.
+func (c *Client) CallStream(ctx context.Context, serviceMethod string, args, reply interface{}) (*Stream, error) {
+	return c.newStream(ctx, serviceMethod, args, reply)
+}
+
+// NewStream invokes a client-streaming or bidirectional-streaming method,
+// reply is a pointer to determine the type of messages from the server.
+func (c *Client) NewStream(ctx context.Context, serviceMethod string, reply interface{}) (*Stream, error) {
+	return c.newStream(ctx, serviceMethod, nil, reply)
+}
+
+func (c *Client) newStream(ctx context.Context, serviceMethod string, args, reply interface{}) (*Stream, error) {
+	if !c.opt.Capabilities.Has(conn.CapStreaming) {
+		return nil, conn.NewError(conn.Unimplemented, "FastRPC client: streaming is not supported by the server")
+	}
+	typ := reflect.TypeOf(reply)
+	if typ == nil || typ.Kind() != reflect.Ptr {
+		return nil, conn.Errorf(conn.InvalidArgument, "FastRPC client: reply must be a pointer, but got %T", reply)
+	}
+
+	s := &Stream{
+		c:       c,
+		typ:     typ.Elem(),
+		notify:  make(chan struct{}, 1),
+		ended:   make(chan struct{}),
+		credits: conn.StreamWindow,
+		wake:    make(chan struct{}, 1),
+	}
+	s.call = newCall(ctx, serviceMethod, args, reply, make(chan *Call, 1))
+	s.call.stream = s
+	s.startErr = c.send(ctx, s.call)
+	go s.watch(ctx)
+	return s, nil
+}
+
+// watch 等待流结束或者 ctx 结束
+func (s *Stream) watch(ctx context.Context) {
+	select {
+	case call := <-s.call.Done:
+		s.finish(call.Error)
+	case <-ctx.Done():
+		if s.c.removeCall(s.call.Seq) == nil {
+			// the stream has just ended
+			call := <-s.call.Done
+			s.finish(call.Error)
+			return
+		}
+		// deadline 已经随请求告知了服务端，只有主动取消时才需要通知服务端
+		if ctx.Err() == context.Canceled {
+			s.c.cancelCall(s.call.Seq)
+		}
+		s.finish(conn.Errorf(conn.CodeOf(ctx.Err()), "FastRPC client: stream failed: %s", ctx.Err()))
+	}
+}
+
+func (s *Stream) finish(err error) {
+	if err == nil {
+		err = io.EOF
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+	close(s.ended)
+}
+
+// push 由 receive 协程调用，将解码后的消息放入队列
+func (s *Stream) push(v reflect.Value) {
+	s.mu.Lock()
+	s.queue = append(s.queue, v)
+	s.mu.Unlock()
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// addCredits 由 receive 协程调用，服务端归还了 n 个额度
+func (s *Stream) addCredits(n uint32) {
+	s.mu.Lock()
+	s.credits += int(n)
+	s.mu.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Send 发送一条消息，额度用完时阻塞，直到服务端归还额度
+// 流已经结束时返回 io.EOF，流结束的原因通过 Recv 获取；请求没有发出时直接返回失败的原因
+func (s *Stream) Send(msg interface{}) error {
+	if s.startErr != nil {
+		return s.startErr
+	}
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+	if s.sendClosed {
+		return conn.NewError(conn.FailedPrecondition, "FastRPC client: send on closed stream")
+	}
+	for {
+		s.mu.Lock()
+		select {
+		case <-s.ended:
+			s.mu.Unlock()
+			return io.EOF
+		default:
+		}
+		if s.credits > 0 {
+			s.credits--
+			s.mu.Unlock()
+			return s.c.writeControl(&conn.Header{Seq: s.call.Seq, Kind: conn.KindStreamMsg}, msg)
+		}
+		s.mu.Unlock()
+
+		select {
+		case <-s.wake:
+		case <-s.ended:
+		}
+	}
+}
+
+// CloseSend 半关闭，通知服务端不会再发送消息，之后仍然可以通过 Recv 读取服务端的消息
+func (s *Stream) CloseSend() error {
+	if s.startErr != nil {
+		return s.startErr
+	}
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+	if s.sendClosed {
+		return nil
+	}
+	s.sendClosed = true
+	select {
+	case <-s.ended:
+		return nil
+	default:
+	}
+	return s.c.writeControl(&conn.Header{Seq: s.call.Seq, Kind: conn.KindStreamEnd}, nil)
+}
+
+// CloseAndRecv 用于客户端流式方法：半关闭后等待服务方法返回，将返回值写入 reply
+func (s *Stream) CloseAndRecv(reply interface{}) error {
+	if err := s.CloseSend(); err != nil {
+		return err
+	}
+	err := s.Recv(reply)
+	if err == io.EOF {
+		return conn.NewError(conn.Internal, "FastRPC client: stream ended without a reply")
+	}
+	if err != nil {
+		return err
+	}
+	<-s.ended
+	if s.err != io.EOF {
+		return s.err
+	}
+	return nil
+}
+
+// Recv 读取下一条消息，流正常结束时返回 io.EOF
+func (s *Stream) Recv(msg interface{}) error {
+	v := reflect.ValueOf(msg)
+	if v.Type() != reflect.PtrTo(s.typ) || v.IsNil() {
+		return conn.Errorf(conn.InvalidArgument, "FastRPC client: expect a %s, but got %T", reflect.PtrTo(s.typ), msg)
+	}
+	for {
+		s.mu.Lock()
+		if len(s.queue) > 0 {
+			m := s.queue[0]
+			s.queue[0] = reflect.Value{}
+			s.queue = s.queue[1:]
+			s.consumed++
+			grant := 0
+			if s.consumed >= conn.StreamWindow/2 {
+				grant, s.consumed = s.consumed, 0
+			}
+			s.mu.Unlock()
+			v.Elem().Set(m.Elem())
+			if grant > 0 {
+				s.grant(grant)
+			}
+			return nil
+		}
+		select {
+		case <-s.ended:
+			err := s.err
+			s.mu.Unlock()
+			return err
+		default:
+		}
+		s.mu.Unlock()
+
+		select {
+		case <-s.notify:
+		case <-s.ended:
+		}
+	}
+}
+
+// grant 将 n 个额度归还给服务端，流已经结束时不需要归还
+func (s *Stream) grant(n int) {
+	select {
+	case <-s.ended:
+		return
+	default:
+	}
+	h := &conn.Header{Seq: s.call.Seq, Kind: conn.KindWindowUpdate, Window: uint32(n)}
+	if err := s.c.writeControl(h, nil); err != nil {
+		log.Println("FastRPC client: send window update error:", err)
+	}
+}
+
+// ReplyMetadata 返回服务端在流结束时附带的元数据，Recv 返回 io.EOF 之后才有效
+func (s *Stream) ReplyMetadata() metadata.MD {
+	select {
+	case <-s.ended:
+		return s.call.ReplyMetadata
+	default:
+		return nil
+	}
+}
+
+// receiveStreamMsg 读取 seq 对应的流的一条消息，流已经结束或被取消时丢弃该消息
+func (c *Client) receiveStreamMsg(seq uint64) error {
+	c.mu.Lock()
+	call := c.pending[seq]
+	c.mu.Unlock()
+	if call == nil || call.stream == nil {
+		return c.cliConn.ReadBody(nil)
+	}
+
+	v := reflect.New(call.stream.typ)
+	if err := c.cliConn.ReadBody(v.Interface()); err != nil {
+		return err
+	}
+	call.stream.push(v)
+	return nil
+}
+
+// receiveWindowUpdate 服务端为 seq 对应的流归还了额度
+func (c *Client) receiveWindowUpdate(h *conn.Header) error {
+	c.mu.Lock()
+	call := c.pending[h.Seq]
+	c.mu.Unlock()
+	if call != nil && call.stream != nil {
+		call.stream.addCredits(h.Window)
+	}
+	return c.cliConn.ReadBody(nil)
+}
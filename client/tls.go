@@ -0,0 +1,46 @@
+package client
+
+import (
+	"crypto/tls"
+	"fastRPC/conn"
+	"net"
+)
+
+/*
+TLS
+DialTLS 在建立 TCP 连接之后先完成 TLS 握手，再进行 Option 的协议交换，握手同样受 ConnectTimeout 的限制。
+config 中没有设置 ServerName 时使用 address 中的主机名校验服务端证书。
+双向认证（mTLS）时在 config.Certificates 中提供客户端证书，服务端校验后可以读取证书中的身份：
+
+	config := &tls.Config{RootCAs: pool, Certificates: []tls.Certificate{cert}}
+	c, err := client.DialTLS("tcp", "10.0.0.1:9999", config)
+
+XDial 支持 tls@host:port 的格式，使用 Dialer.TLSConfig，为 nil 时使用系统默认的根证书。
+*/
+
+// DialTLS connects to an RPC server at the specified network address over TLS.
+func DialTLS(network, address string, config *tls.Config, opts ...*conn.Option) (*Client, error) {
+	return dialTimeout(newTLSClientFunc(address, config), network, address, opts...)
+}
+
+// newTLSClientFunc 返回在 TLS 握手之后创建 Client 的 newClientFunc
+func newTLSClientFunc(address string, config *tls.Config) newClientFunc {
+	if config == nil {
+		config = &tls.Config{}
+	}
+	if config.ServerName == "" {
+		config = config.Clone()
+		if host, _, err := net.SplitHostPort(address); err == nil {
+			config.ServerName = host
+		} else {
+			config.ServerName = address
+		}
+	}
+	return func(nc net.Conn, opt *conn.Option) (*Client, error) {
+		tc := tls.Client(nc, config)
+		if err := tc.Handshake(); err != nil {
+			return nil, conn.Errorf(conn.Unauthenticated, "FastRPC client: tls handshake error: %v", err)
+		}
+		return NewClient(tc, opt)
+	}
+}
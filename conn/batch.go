@@ -0,0 +1,50 @@
+package conn
+
+/*
+批量调用
+一个批量请求把多个调用打包在一帧中发送，Header 的 Kind 为 KindBatch，ServiceMethod 为空，
+消息体是 []BatchItem，响应的消息体是与之一一对应的 []BatchResult：
+
+	client -> | Header{Seq: 1, Kind: KindBatch} | []BatchItem{{"Foo.Sum", Args}, {"Foo.Mul", Args}} |
+	server <- | Header{Seq: 1, Kind: KindBatch} | []BatchResult{{Reply}, {Error, Code}} |
+
+不同调用的参数类型各不相同，因此每一项的 Args 和 Reply 都通过编解码器的 ValueCodec 单独编码为字节。
+只有实现了 ValueCodec 的编解码器才支持批量调用，GobConn 和 JsonConn 都实现了它。
+每一项的错误只影响该项本身，Header 中的错误表示整个批量请求失败（例如超时、编解码器不支持）。
+*/
+
+// MaxBatchSize 一个批量请求最多包含的调用数
+const MaxBatchSize = 1024
+
+// ValueCodec 可以把单个值独立编码为字节，编码结果不依赖连接上之前的数据
+type ValueCodec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// BatchItem 批量请求中的一个调用
+type BatchItem struct {
+	ServiceMethod string
+	Args          []byte // encoded by ValueCodec, empty means no args
+}
+
+// BatchResult 批量请求中一个调用的结果，错误字段的含义与 Header 相同
+type BatchResult struct {
+	Error   string
+	Code    Code              `json:",omitempty"`
+	Details map[string]string `json:",omitempty"`
+	Reply   []byte            // encoded by ValueCodec, empty when an error occurs
+}
+
+// SetError 将 err 写入结果，err 为 nil 时清空错误
+func (r *BatchResult) SetError(err error) {
+	var h Header
+	h.SetError(err)
+	r.Error, r.Code, r.Details = h.Error, h.Code, h.Details
+}
+
+// Err 从结果中还原错误，没有错误时返回 nil
+func (r *BatchResult) Err() error {
+	h := Header{Error: r.Error, Code: r.Code, Details: r.Details}
+	return h.Err()
+}
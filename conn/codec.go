@@ -0,0 +1,61 @@
+package conn
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// 编解码器的注册表
+// 第三方的编解码器可以在自己包的 init() 中调用 RegisterCodec 注册，客户端和服务端通过 LookupCodec 查找，
+// 注册表由读写锁保护，注册和查找可以并发进行。
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = make(map[Type]NewConnFunc)
+)
+
+func init() {
+	// 启动时Conn的实例向接口注册
+	_ = RegisterCodec(GobType, NewGobConn)
+	_ = RegisterCodec(JsonType, NewJsonConn)
+}
+
+// RegisterCodec 注册编解码器，同一个 Type 只能注册一次
+func RegisterCodec(typ Type, f NewConnFunc) error {
+	if typ == "" {
+		return errors.New("FastRPC conn: codec type is empty")
+	}
+	if f == nil {
+		return fmt.Errorf("FastRPC conn: codec %s is nil", typ)
+	}
+
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	if _, dup := codecs[typ]; dup {
+		return fmt.Errorf("FastRPC conn: codec already registered: %s", typ)
+	}
+	codecs[typ] = f
+	return nil
+}
+
+// LookupCodec 查找 typ 对应的编解码器
+func LookupCodec(typ Type) (NewConnFunc, bool) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	f, ok := codecs[typ]
+	return f, ok
+}
+
+// Codecs 返回所有已注册的编解码器类型，按名称排序
+func Codecs() []Type {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	types := make([]Type, 0, len(codecs))
+	for typ := range codecs {
+		types = append(types, typ)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
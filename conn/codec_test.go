@@ -0,0 +1,33 @@
+package conn
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestRegisterCodec(t *testing.T) {
+	_assert(RegisterCodec(GobType, NewGobConn) != nil, "expect a duplicate codec error")
+	_assert(RegisterCodec("", NewGobConn) != nil, "expect an empty type error")
+	_assert(RegisterCodec("application/nil", nil) != nil, "expect a nil codec error")
+
+	// 注册与查找并发进行
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_ = RegisterCodec("application/x-test", NewJsonConn)
+		}()
+		go func() {
+			defer wg.Done()
+			_, _ = LookupCodec(GobType)
+			_ = Codecs()
+		}()
+	}
+	wg.Wait()
+
+	f, ok := LookupCodec("application/x-test")
+	_assert(ok && f != nil, "failed to lookup application/x-test")
+	types := Codecs()
+	_assert(len(types) == 3 && types[0] == GobType, "unexpected codecs %v", types)
+}
@@ -0,0 +1,116 @@
+package conn
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+)
+
+/*
+消息体压缩
+压缩发生在 Framer 中，与具体的编解码方式无关，任何通过 RegisterCodec 注册的 Conn 都可以使用。
+压缩算法由客户端在 Option 中指定，服务端支持该算法时回复相同的值，否则回复 CompressNone。
+只有超过阈值的帧才会被压缩，并在帧头的 Flags 中标记 flagCompressed，较小的帧（例如 Header）保持原样。
+*/
+
+type CompressType string
+
+const (
+	CompressNone  CompressType = ""
+	CompressGzip  CompressType = "gzip"
+	CompressFlate CompressType = "flate" // 默认使用 flate.BestSpeed，速度优先
+
+	defaultCompressThreshold = 1024 // 超过 1KB 的帧才压缩
+)
+
+// compressor 对一帧的内容进行压缩和解压
+// 写帧和读帧可能发生在不同的协程中，因此压缩和解压不能共享状态
+type compressor interface {
+	compress(dst *bytes.Buffer, src []byte) error
+	decompress(src []byte) ([]byte, error)
+}
+
+// newCompressorFuncMap 支持的压缩算法，level 为 0 时使用各算法的默认级别
+var newCompressorFuncMap = map[CompressType]func(level int) (compressor, error){
+	CompressGzip: func(level int) (compressor, error) {
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		w, err := gzip.NewWriterLevel(io.Discard, level)
+		if err != nil {
+			return nil, err
+		}
+		return &gzipCompressor{w: w}, nil
+	},
+	CompressFlate: func(level int) (compressor, error) {
+		if level == 0 {
+			level = flate.BestSpeed
+		}
+		w, err := flate.NewWriter(io.Discard, level)
+		if err != nil {
+			return nil, err
+		}
+		return &flateCompressor{w: w}, nil
+	},
+}
+
+// readAllLimited 读出解压后的全部内容，解压后的大小同样受 maxFrameSize 的限制
+func readAllLimited(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxFrameSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxFrameSize {
+		return nil, ErrFrameTooLarge
+	}
+	return data, nil
+}
+
+type gzipCompressor struct {
+	w *gzip.Writer
+	r *gzip.Reader
+}
+
+func (c *gzipCompressor) compress(dst *bytes.Buffer, src []byte) error {
+	c.w.Reset(dst)
+	if _, err := c.w.Write(src); err != nil {
+		return err
+	}
+	return c.w.Close()
+}
+
+func (c *gzipCompressor) decompress(src []byte) ([]byte, error) {
+	var err error
+	if c.r == nil {
+		c.r, err = gzip.NewReader(bytes.NewReader(src))
+	} else {
+		err = c.r.Reset(bytes.NewReader(src))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return readAllLimited(c.r)
+}
+
+type flateCompressor struct {
+	w *flate.Writer
+	r io.ReadCloser
+}
+
+func (c *flateCompressor) compress(dst *bytes.Buffer, src []byte) error {
+	c.w.Reset(dst)
+	if _, err := c.w.Write(src); err != nil {
+		return err
+	}
+	return c.w.Close()
+}
+
+func (c *flateCompressor) decompress(src []byte) ([]byte, error) {
+	if c.r == nil {
+		c.r = flate.NewReader(bytes.NewReader(src))
+	} else if err := c.r.(flate.Resetter).Reset(bytes.NewReader(src), nil); err != nil {
+		return nil, err
+	}
+	return readAllLimited(c.r)
+}
@@ -0,0 +1,54 @@
+package conn
+
+import (
+	"compress/flate"
+	"strings"
+	"testing"
+)
+
+type record struct {
+	ID   int
+	Name string
+}
+
+func TestFramer_Compression(t *testing.T) {
+	records := make([]record, 1000)
+	for i := range records {
+		records[i] = record{ID: i, Name: strings.Repeat("fastrpc", 4)}
+	}
+
+	for _, typ := range Codecs() {
+		f, _ := LookupCodec(typ)
+		for _, opt := range []*Option{
+			{Compression: CompressGzip},
+			{Compression: CompressFlate, CompressLevel: flate.BestSpeed},
+		} {
+			t.Run(string(typ)+"/"+string(opt.Compression), func(t *testing.T) {
+				var raw, compressed bufferConn
+				_ = f(NewFramer(&raw)).Write(&Header{Seq: 1}, records)
+				framer := NewFramer(&compressed)
+				_assert(framer.SetCompression(opt) == nil, "failed to set compression")
+				_ = f(framer).Write(&Header{Seq: 1}, records)
+				_assert(compressed.Len() < raw.Len()/2, "expect compressed frames, %d >= %d", compressed.Len(), raw.Len())
+				// header is smaller than the threshold and stays uncompressed
+				_assert(compressed.Bytes()[4] == 0, "expect an uncompressed header")
+
+				framer = NewFramer(&compressed)
+				_ = framer.SetCompression(opt)
+				r := f(framer)
+				var h Header
+				var got []record
+				_assert(r.ReadHeader(&h) == nil && h.Seq == 1, "failed to read header")
+				_assert(r.ReadBody(&got) == nil && len(got) == len(records) && got[999] == records[999], "failed to read body")
+			})
+		}
+	}
+}
+
+func TestNegotiate_Compression(t *testing.T) {
+	opt := &Option{Version: ProtocolVersion, Capabilities: SupportedCapabilities, Compression: "zstd"}
+	_assert(Negotiate(opt, SupportedCapabilities) == nil && opt.Compression == CompressNone, "expect no compression")
+	opt = &Option{Version: ProtocolVersion, Capabilities: SupportedCapabilities, Compression: CompressGzip}
+	_assert(Negotiate(opt, SupportedCapabilities&^CapCompression) == nil && opt.Compression == CompressNone,
+		"expect no compression without CapCompression")
+}
@@ -15,9 +15,10 @@ func (t *T) MethodName(argType T1, replyType *T2) error
 剩余的信息放在header（"Arith.Multiply"和err）
 
 服务端收到的报文格式：
-| Option{MagicNumber: xxx, ConnType: xxx} | Header{ServiceMethod ...} | Body interface{} |
-| <------      固定 JSON 编码      ------>  | <-------   编码方式由 CodeType 决定   ------->|
-服务端首先使用 JSON 解码 Option，然后通过 Option 的 CodeType 解码剩余的内容
+| Preamble | Option{MagicNumber: xxx, ConnType: xxx} | Frame(Header{ServiceMethod ...}) | Frame(Body interface{}) |
+| 二进制前导 | <------      固定 JSON 编码      ------> | <--------------   编码方式由 CodeType 决定   --------------> |
+服务端首先读取前导并使用 JSON 解码 Option，然后通过 Option 的 CodeType 解码剩余的内容
+前导和帧的具体格式见 frame.go，每一段数据都带有长度，因此不会出现粘包问题
 
 在一次连接中，Option 固定在报文的最开始，Header 和 Body 可以有多个，即报文可能是这样的
 | Option | Header1 | Body1 | Header2 | Body2 | ...
@@ -32,22 +33,69 @@ const (
 )
 
 // Option 客户端与服务端的通信需要协商一些内容
-// 目前FastRPC需要协商的唯一一项内容是消息的编解码方式。我们将这部分信息，放到结构体 Option 中承载
+// FastRPC需要协商的内容包括消息的编解码方式、协议版本和双方支持的能力。我们将这部分信息，放到结构体 Option 中承载
 type Option struct {
 	MagicNumber int  // MagicNumber 标记这是一个FastRPC请求
 	ConnType    Type // ConnType 支持GobType和JsonType
 
+	// for version negotiation, see version.go
+	Version      int        // 协议版本，服务端回复协商后的版本
+	Capabilities Capability // 支持的能力，服务端回复双方能力的交集，0 表示使用默认值，不启用任何能力时使用 CapNone
+
+	// for compression, see compress.go
+	Compression       CompressType // 压缩算法，服务端不支持时回复 CompressNone
+	CompressLevel     int          // 压缩级别，0 表示使用算法的默认级别
+	CompressThreshold int          // 超过该字节数的帧才压缩，0 表示使用默认值
+
+	// for flow control, the server replies the number of requests the client can send before the server grants more
+	ConnWindow uint32 `json:",omitempty"`
+
 	// for timeout operation
 	ConnectTimeout time.Duration // 0 means no limit
 	HandleTimeout  time.Duration
+
+	// 服务端拒绝连接时，在回复的 Option 中说明原因
+	Error string `json:",omitempty"`
 }
 
 var DefaultOption = &Option{
 	MagicNumber:    MagicNumber,
 	ConnType:       GobType,
+	Version:        ProtocolVersion,
+	Capabilities:   SupportedCapabilities,
 	ConnectTimeout: time.Second * 10,
 }
 
+// Kind 区分 Header 的类型，默认的 KindCall 即普通的请求和响应，其余的类型用于控制消息
+type Kind uint8
+
+const (
+	KindCall         Kind = iota // request or response of a call
+	KindCancel                   // the client cancels the request of Seq, without body
+	KindGoAway                   // the server is shutting down and accepts no new requests, without body
+	KindStreamMsg                // a message of the stream of Seq, the body is the message
+	KindStreamEnd                // the end of the stream of Seq, from server: Error/Code is the final status; from client: half-close
+	KindWindowUpdate             // the receiver of the stream of Seq grants Window more messages to the sender, Seq 0 means the connection, without body
+	KindOneWay                   // a request without response, Seq is 0
+	KindBatch                    // request or response of a batch of calls, see batch.go
+	KindPush                     // the server pushes a message of topic ServiceMethod, Seq is 0, the body is the message
+	KindSubscribe                // the client subscribes to topic ServiceMethod, without body
+	KindUnsubscribe              // the client unsubscribes from topic ServiceMethod, without body
+	KindReverseCall              // the server calls a method registered on the client, Seq is in the server's own space
+	KindReverseReply             // the client replies KindReverseCall of Seq
+)
+
+// StreamWindow 每个流初始的发送窗口（消息数），发送方最多发送 StreamWindow 条未被确认的消息，
+// 接收方每消费一部分消息就通过 KindWindowUpdate 归还相应的额度
+const StreamWindow = 64
+
+// DefaultConnWindow 每个连接默认的请求窗口，即服务端在一个连接上同时处理的最大请求数，
+// 服务端每处理完一部分请求就通过 Seq 为 0 的 KindWindowUpdate 归还相应的额度
+const DefaultConnWindow = 1024
+
+// MaxTopics 每个连接最多订阅的主题数，超出的 KindSubscribe 被服务端忽略
+const MaxTopics = 256
+
 type Header struct {
 	// name of service or method, e.g. "Service.Method"
 	ServiceMethod string
@@ -56,6 +104,19 @@ type Header struct {
 	// if an error occurs on server side, the error message will be put in Error
 	// on client side, Error should be null in the beginning
 	Error string
+	// code and details of the error, see error.go
+	Code    Code              `json:",omitempty"`
+	Details map[string]string `json:",omitempty"`
+	// request or response metadata, e.g. trace id, auth token
+	// only sent when CapMetadata is negotiated
+	Metadata map[string]string `json:",omitempty"`
+	// the remaining time of the caller's deadline when the request is sent, 0 means no deadline
+	// the server handles the request within the smaller one of Timeout and Option.HandleTimeout
+	Timeout time.Duration `json:",omitempty"`
+	// type of the message, control messages are only sent when the corresponding capability is negotiated
+	Kind Kind `json:",omitempty"`
+	// number of messages granted by KindWindowUpdate
+	Window uint32 `json:",omitempty"`
 }
 
 // Conn 抽象出对消息体进行编解码的接口 Conn，抽象出接口是为了实现不同的 Conn 实例
@@ -67,14 +128,5 @@ type Conn interface {
 }
 
 // NewConnFunc 相当于一个函数指针
-type NewConnFunc func(closer io.ReadWriteCloser) Conn
-
-var NewConnFuncMap map[Type]NewConnFunc
-
-func init() {
-	NewConnFuncMap = make(map[Type]NewConnFunc)
-
-	// 启动时Conn的实例向接口注册
-	NewConnFuncMap[GobType] = NewGobConn
-	NewConnFuncMap[JsonType] = NewJsonConn
-}
+// Conn 的实现基于 Framer 读写帧，只需要负责 Header 和 Body 的编解码
+type NewConnFunc func(framer *Framer) Conn
@@ -17,6 +17,12 @@ func ParseOptions(opts ...*Option) (*Option, error) {
 	if opt.ConnType == "" {
 		opt.ConnType = DefaultOption.ConnType
 	}
+	if opt.Version == 0 {
+		opt.Version = DefaultOption.Version
+	}
+	if opt.Capabilities == 0 {
+		opt.Capabilities = DefaultOption.Capabilities
+	}
 
 	return opt, nil
 }
@@ -0,0 +1,145 @@
+package conn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+/*
+结构化的错误
+服务方法返回的错误通过 Header 的 Error、Code 和 Details 三个字段传给客户端，客户端还原为 *Error：
+
+	// server
+	return conn.Errorf(conn.NotFound, "user %d not found", id)
+	// client
+	var e *conn.Error
+	if errors.As(err, &e) && e.Code == conn.NotFound { ... }
+
+服务方法返回的普通 error 以 Unknown 的错误码传输，错误信息保持不变。
+*/
+
+// Code 错误码，取值与 gRPC 的错误码保持一致，便于与其他系统对接
+type Code uint32
+
+const (
+	OK                 Code = iota // not an error
+	Canceled                       // the operation was canceled by the caller
+	Unknown                        // unknown error, e.g. an error returned by a service method without a code
+	InvalidArgument                // the client specified an invalid argument
+	DeadlineExceeded               // the deadline expired before the operation could complete
+	NotFound                       // some requested entity was not found
+	AlreadyExists                  // the entity that a client attempted to create already exists
+	PermissionDenied               // the caller does not have permission to execute the operation
+	ResourceExhausted              // some resource has been exhausted
+	FailedPrecondition             // the system is not in a state required for the operation
+	Aborted                        // the operation was aborted
+	OutOfRange                     // the operation was attempted past the valid range
+	Unimplemented                  // the operation is not implemented or not supported
+	Internal                       // internal error, e.g. a service method panics
+	Unavailable                    // the service is currently unavailable, the caller may retry
+	DataLoss                       // unrecoverable data loss or corruption
+	Unauthenticated                // the request does not have valid authentication credentials
+)
+
+var codeNames = [...]string{
+	OK:                 "OK",
+	Canceled:           "Canceled",
+	Unknown:            "Unknown",
+	InvalidArgument:    "InvalidArgument",
+	DeadlineExceeded:   "DeadlineExceeded",
+	NotFound:           "NotFound",
+	AlreadyExists:      "AlreadyExists",
+	PermissionDenied:   "PermissionDenied",
+	ResourceExhausted:  "ResourceExhausted",
+	FailedPrecondition: "FailedPrecondition",
+	Aborted:            "Aborted",
+	OutOfRange:         "OutOfRange",
+	Unimplemented:      "Unimplemented",
+	Internal:           "Internal",
+	Unavailable:        "Unavailable",
+	DataLoss:           "DataLoss",
+	Unauthenticated:    "Unauthenticated",
+}
+
+func (c Code) String() string {
+	if int(c) < len(codeNames) {
+		return codeNames[c]
+	}
+	return fmt.Sprintf("Code(%d)", uint32(c))
+}
+
+// Error 带有错误码的错误
+type Error struct {
+	Code    Code
+	Message string
+	Details map[string]string // optional details, e.g. the invalid field
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Unwrap 错误码为 Canceled、DeadlineExceeded 时返回对应的 context 错误，
+// 使得 errors.Is(err, context.DeadlineExceeded) 对超时的调用同样成立
+func (e *Error) Unwrap() error {
+	switch e.Code {
+	case Canceled:
+		return context.Canceled
+	case DeadlineExceeded:
+		return context.DeadlineExceeded
+	}
+	return nil
+}
+
+// NewError 构造函数
+func NewError(code Code, msg string) *Error {
+	return &Error{Code: code, Message: msg}
+}
+
+// Errorf 构造函数，错误信息按照 fmt.Sprintf 格式化
+func Errorf(code Code, format string, a ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, a...)}
+}
+
+// CodeOf 返回 err 的错误码，err 为 nil 时返回 OK，没有错误码的 err 返回 Unknown
+func CodeOf(err error) Code {
+	var e *Error
+	switch {
+	case err == nil:
+		return OK
+	case errors.As(err, &e):
+		return e.Code
+	case errors.Is(err, context.DeadlineExceeded):
+		return DeadlineExceeded
+	case errors.Is(err, context.Canceled):
+		return Canceled
+	}
+	return Unknown
+}
+
+// SetError 将 err 写入 Header，err 为 nil 时清空错误
+func (h *Header) SetError(err error) {
+	h.Error, h.Code, h.Details = "", OK, nil
+	if err == nil {
+		return
+	}
+	h.Error, h.Code = err.Error(), CodeOf(err)
+	var e *Error
+	if errors.As(err, &e) {
+		h.Details = e.Details
+	}
+}
+
+// Err 从 Header 中还原错误，没有错误时返回 nil
+// 旧版本的服务端只设置 Error 字段，此时错误码为 Unknown
+func (h *Header) Err() error {
+	if h.Error == "" && h.Code == OK {
+		return nil
+	}
+	code := h.Code
+	if code == OK {
+		code = Unknown
+	}
+	return &Error{Code: code, Message: h.Error, Details: h.Details}
+}
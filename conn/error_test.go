@@ -0,0 +1,42 @@
+package conn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestHeader_Err(t *testing.T) {
+	var h Header
+	h.SetError(&Error{Code: NotFound, Message: "100% not found", Details: map[string]string{"id": "1"}})
+	for _, f := range []NewConnFunc{NewGobConn, NewJsonConn} {
+		c := f(NewFramer(&bufferConn{}))
+		_assert(c.Write(&h, nil) == nil, "failed to write header")
+		var got Header
+		_assert(c.ReadHeader(&got) == nil && c.ReadBody(nil) == nil, "failed to read header")
+
+		var e *Error
+		err := got.Err()
+		_assert(errors.As(err, &e), "expect a *Error, but got %T", err)
+		_assert(e.Code == NotFound && e.Message == "100% not found" && e.Details["id"] == "1", "unexpected error: %+v", e)
+	}
+
+	h.SetError(nil)
+	_assert(h.Err() == nil && h.Code == OK, "expect no error after SetError(nil)")
+	h.Error = "error from an old server"
+	_assert(CodeOf(h.Err()) == Unknown, "expect Unknown for an error without code")
+}
+
+func TestCodeOf(t *testing.T) {
+	_assert(CodeOf(nil) == OK, "expect OK for nil")
+	_assert(CodeOf(errors.New("foo")) == Unknown, "expect Unknown for a plain error")
+	_assert(CodeOf(context.DeadlineExceeded) == DeadlineExceeded, "expect DeadlineExceeded")
+	_assert(CodeOf(context.Canceled) == Canceled, "expect Canceled")
+	err := fmt.Errorf("wrapped: %w", NewError(Unavailable, "foo"))
+	_assert(CodeOf(err) == Unavailable, "expect the code of the wrapped error")
+	_assert(errors.Is(NewError(DeadlineExceeded, "foo"), context.DeadlineExceeded), "expect DeadlineExceeded to unwrap to the context error")
+	_assert(errors.Is(NewError(Canceled, "foo"), context.Canceled), "expect Canceled to unwrap to the context error")
+	_assert(!errors.Is(err, context.Canceled), "expect other codes not to unwrap to a context error")
+	_assert(Unavailable.String() == "Unavailable" && Code(100).String() == "Code(100)", "unexpected code name")
+}
@@ -0,0 +1,199 @@
+package conn
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+/*
+为了解决粘包问题，FastRPC 在 TCP 字节流之上定义了固定的报文格式：
+
+连接建立后，双方首先交换一个二进制前导（Preamble），其后紧跟 JSON 编码的 Option：
+| Magic uint32 | WireVersion uint8 | OptionLen uint32 | Option (JSON, OptionLen 字节) |
+
+此后所有的 Header 和 Body 都被封装为独立的帧（Frame）：
+| Length uint32 | Flags uint8 | Payload (Length 字节) |
+Flags 目前只有一个标记位 flagCompressed，表示 Payload 经过了压缩（见 compress.go）
+
+所有整数均为大端序。由于每一段数据的长度都是事先确定的，读取方只会读走属于自己的字节，
+Option 与后续的 Header、Body 之间不会再互相“粘”在一起，与具体的编解码方式无关。
+*/
+
+const (
+	WireVersion    = 1 // 前导与帧格式的版本号，帧格式发生不兼容的变化时递增
+	preambleLen    = 4 + 1 + 4
+	frameHeaderLen = 4 + 1
+
+	maxOptionSize = 1 << 16 // Option 的最大长度，防止读取到错误数据时分配过大的内存
+	maxFrameSize  = 1 << 26 // 单帧的最大长度（64MB）
+
+	flagCompressed = 1 << 0
+)
+
+var (
+	ErrInvalidMagic   = errors.New("FastRPC conn: invalid magic number")
+	ErrFrameTooLarge  = errors.New("FastRPC conn: frame too large")
+	ErrOptionTooLarge = errors.New("FastRPC conn: option too large")
+)
+
+// WriteOption 写入前导和 JSON 编码的 Option，前导和 Option 通过一次 Write 发出
+func WriteOption(w io.Writer, opt *Option) error {
+	data, err := json.Marshal(opt)
+	if err != nil {
+		return err
+	}
+	if len(data) > maxOptionSize {
+		return ErrOptionTooLarge
+	}
+
+	buf := make([]byte, preambleLen+len(data))
+	binary.BigEndian.PutUint32(buf[0:4], MagicNumber)
+	buf[4] = WireVersion
+	binary.BigEndian.PutUint32(buf[5:9], uint32(len(data)))
+	copy(buf[preambleLen:], data)
+	_, err = w.Write(buf)
+	return err
+}
+
+// ReadOption 读取前导和 Option，只会读走前导中声明的字节数，不会多读属于后续帧的数据
+func ReadOption(r io.Reader) (*Option, error) {
+	var preamble [preambleLen]byte
+	if _, err := io.ReadFull(r, preamble[:]); err != nil {
+		return nil, err
+	}
+	if magic := binary.BigEndian.Uint32(preamble[0:4]); magic != MagicNumber {
+		return nil, fmt.Errorf("%w: %x", ErrInvalidMagic, magic)
+	}
+	if version := preamble[4]; version != WireVersion {
+		return nil, fmt.Errorf("FastRPC conn: unsupported wire version %d", version)
+	}
+	n := binary.BigEndian.Uint32(preamble[5:9])
+	if n > maxOptionSize {
+		return nil, ErrOptionTooLarge
+	}
+
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	opt := new(Option)
+	if err := json.Unmarshal(data, opt); err != nil {
+		return nil, err
+	}
+	return opt, nil
+}
+
+// ============================================================
+
+// Framer 负责在连接上读写帧，Conn 的实现只需要关心如何把 Header 和 Body 编码为帧的内容（Payload）
+// Framer 本身不是并发安全的，调用方需要保证同一时刻只有一个读者和一个写者
+type Framer struct {
+	conn io.ReadWriteCloser // 连接实例，由构造函数传入
+	r    *bufio.Reader
+	w    *bufio.Writer // 带缓冲的Writer，一次请求的多个帧通过 Flush 一起发出
+
+	// 读写分别使用各自的帧头缓冲区，读和写可能发生在不同的协程中
+	rhdr [frameHeaderLen]byte
+	whdr [frameHeaderLen]byte
+
+	// for compression, nil means no compression
+	compressor compressor
+	threshold  int          // 超过该长度的帧才会被压缩
+	cbuf       bytes.Buffer // 压缩后的帧内容
+}
+
+// NewFramer 构造函数
+func NewFramer(conn io.ReadWriteCloser) *Framer {
+	return &Framer{
+		conn: conn,
+		r:    bufio.NewReader(conn),
+		w:    bufio.NewWriter(conn),
+	}
+}
+
+// SetCompression 根据协商后的 Option 设置压缩算法，之后写出的帧超过阈值时会被压缩
+func (f *Framer) SetCompression(opt *Option) error {
+	if opt.Compression == CompressNone {
+		f.compressor = nil
+		return nil
+	}
+	newCompressor := newCompressorFuncMap[opt.Compression]
+	if newCompressor == nil {
+		return fmt.Errorf("FastRPC conn: unsupported compression %q", opt.Compression)
+	}
+	c, err := newCompressor(opt.CompressLevel)
+	if err != nil {
+		return err
+	}
+	f.compressor = c
+	f.threshold = opt.CompressThreshold
+	if f.threshold <= 0 {
+		f.threshold = defaultCompressThreshold
+	}
+	return nil
+}
+
+// ReadFrame 读取下一帧，返回帧的内容
+func (f *Framer) ReadFrame() ([]byte, error) {
+	if _, err := io.ReadFull(f.r, f.rhdr[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(f.rhdr[0:4])
+	if n > maxFrameSize {
+		return nil, ErrFrameTooLarge
+	}
+
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(f.r, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	if f.rhdr[4]&flagCompressed != 0 {
+		if f.compressor == nil {
+			return nil, errors.New("FastRPC conn: received a compressed frame without negotiated compression")
+		}
+		return f.compressor.decompress(payload)
+	}
+	return payload, nil
+}
+
+// WriteFrame 将一帧写入缓冲区，需要调用 Flush 才会真正发出
+func (f *Framer) WriteFrame(payload []byte) error {
+	if len(payload) > maxFrameSize {
+		return ErrFrameTooLarge
+	}
+	var flags byte
+	if f.compressor != nil && len(payload) > f.threshold {
+		f.cbuf.Reset()
+		if err := f.compressor.compress(&f.cbuf, payload); err != nil {
+			return err
+		}
+		payload, flags = f.cbuf.Bytes(), flagCompressed
+	}
+
+	binary.BigEndian.PutUint32(f.whdr[0:4], uint32(len(payload)))
+	f.whdr[4] = flags
+	if _, err := f.w.Write(f.whdr[:]); err != nil {
+		return err
+	}
+	_, err := f.w.Write(payload)
+	return err
+}
+
+// Flush 将缓冲区中的帧写入连接
+func (f *Framer) Flush() error {
+	return f.w.Flush()
+}
+
+// Close 关闭连接
+func (f *Framer) Close() error {
+	return f.conn.Close()
+}
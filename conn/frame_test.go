@@ -0,0 +1,73 @@
+package conn
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+)
+
+func _assert(condition bool, msg string, v ...interface{}) {
+	if !condition {
+		panic(fmt.Sprintf("assertion failed: "+msg, v...))
+	}
+}
+
+// bufferConn 用内存缓冲区模拟连接，写入的数据可以被原样读出
+type bufferConn struct {
+	bytes.Buffer
+}
+
+func (b *bufferConn) Close() error { return nil }
+
+var _ io.ReadWriteCloser = (*bufferConn)(nil)
+
+// TestFramer_Sticky Option 与多个 Header、Body 一次性到达时，仍然能够被逐个正确解析
+func TestFramer_Sticky(t *testing.T) {
+	for _, typ := range Codecs() {
+		f, _ := LookupCodec(typ)
+		t.Run(string(typ), func(t *testing.T) {
+			var stream bufferConn
+			_ = WriteOption(&stream, &Option{MagicNumber: MagicNumber, ConnType: typ})
+			w := f(NewFramer(&stream))
+			for i := 0; i < 3; i++ {
+				_ = w.Write(&Header{ServiceMethod: "Foo.Sum", Seq: uint64(i)}, fmt.Sprintf("req%d", i))
+			}
+
+			opt, err := ReadOption(&stream)
+			_assert(err == nil && opt.ConnType == typ, "failed to read option: %v", err)
+			r := f(NewFramer(&stream))
+			for i := 0; i < 3; i++ {
+				var h Header
+				var body string
+				_assert(r.ReadHeader(&h) == nil && h.Seq == uint64(i), "failed to read header %d", i)
+				if i == 1 {
+					_assert(r.ReadBody(nil) == nil, "failed to discard body %d", i)
+					continue
+				}
+				_assert(r.ReadBody(&body) == nil && body == fmt.Sprintf("req%d", i), "unexpected body %q", body)
+			}
+		})
+	}
+}
+
+func TestConn_Metadata(t *testing.T) {
+	for _, typ := range Codecs() {
+		f, _ := LookupCodec(typ)
+		t.Run(string(typ), func(t *testing.T) {
+			var stream bufferConn
+			md := map[string]string{"trace-id": "abc", "tenant": "t1"}
+			_ = f(NewFramer(&stream)).Write(&Header{ServiceMethod: "Foo.Sum", Seq: 1, Metadata: md}, nil)
+
+			var h Header
+			r := f(NewFramer(&stream))
+			_assert(r.ReadHeader(&h) == nil && r.ReadBody(nil) == nil, "failed to read frames")
+			_assert(len(h.Metadata) == 2 && h.Metadata["trace-id"] == "abc", "unexpected metadata %v", h.Metadata)
+		})
+	}
+}
+
+func TestReadOption_InvalidMagic(t *testing.T) {
+	_, err := ReadOption(bytes.NewReader([]byte{0, 0, 0, 1, WireVersion, 0, 0, 0, 0}))
+	_assert(err != nil, "expect an invalid magic number error")
+}
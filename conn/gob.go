@@ -1,49 +1,80 @@
 package conn
 
 import (
-	"bufio"
+	"bytes"
 	"encoding/gob"
-	"io"
 	"log"
 )
 
 // gob消息的编解码器
+// gob 是有状态的编码：类型信息只在第一次出现时发送。因此每个连接各自持有一对 encoder/decoder，
+// encoder 先编码到 wbuf 中再整体作为一帧写出，decoder 则从 rbuf 中读取当前帧的内容。
 
 type GobConn struct {
-	conn    io.ReadWriteCloser // 连接实例，由构造函数传入
-	buf     *bufio.Writer      // 防止阻塞而创建的带缓冲的Writer（能提升性能）
-	encoder *gob.Encoder       // 编码
-	decoder *gob.Decoder       // 解码
+	framer  *Framer       // 帧的读写，由构造函数传入
+	rbuf    *bytes.Buffer // 当前读取到的帧
+	wbuf    *bytes.Buffer // 正在编码的帧
+	encoder *gob.Encoder  // 编码
+	decoder *gob.Decoder  // 解码
 }
 
 // ==============================================
 // GobConn的方法，GobConn是Conn实例的一种类型
 // ==============================================
 
+// readFrame 读取下一帧，并交给 decoder 解码
+func (c *GobConn) readFrame(v interface{}) error {
+	payload, err := c.framer.ReadFrame()
+	if err != nil {
+		return err
+	}
+	// 空帧代表没有消息体
+	if len(payload) == 0 {
+		return nil
+	}
+	c.rbuf.Reset()
+	c.rbuf.Write(payload)
+	// 即使 v 为 nil 也需要解码，帧中可能携带了后续帧依赖的类型信息
+	return c.decoder.Decode(v)
+}
+
+// writeFrame 编码 v 并写出一帧，v 为 nil 时写出一个空帧
+func (c *GobConn) writeFrame(v interface{}) error {
+	c.wbuf.Reset()
+	if v != nil {
+		if err := c.encoder.Encode(v); err != nil {
+			return err
+		}
+	}
+	return c.framer.WriteFrame(c.wbuf.Bytes())
+}
+
 // ReadHeader 对头部进行解码
 func (c *GobConn) ReadHeader(header *Header) error {
-	return c.decoder.Decode(header)
+	return c.readFrame(header)
 }
 
 // ReadBody 对消息体进行解码
 func (c *GobConn) ReadBody(body interface{}) error {
-	return c.decoder.Decode(body)
+	return c.readFrame(body)
 }
 
 // Write 写数据
 func (c *GobConn) Write(header *Header, body interface{}) (err error) {
 	defer func() {
-		_ = c.buf.Flush()
+		if err == nil {
+			err = c.framer.Flush()
+		}
 		if err != nil {
 			_ = c.Close()
 		}
 	}()
 
-	if err := c.encoder.Encode(header); err != nil {
+	if err := c.writeFrame(header); err != nil {
 		log.Println("FastRPC conn: gob error while encoding header:", err)
 		return err
 	}
-	if err := c.encoder.Encode(body); err != nil {
+	if err := c.writeFrame(body); err != nil {
 		log.Println("FastRPC conn: gob error while encoding body:", err)
 		return err
 	}
@@ -51,21 +82,37 @@ func (c *GobConn) Write(header *Header, body interface{}) (err error) {
 	return nil
 }
 
+// Marshal 使用新的 encoder 独立编码 v，结果中带有完整的类型信息，用于批量调用
+func (c *GobConn) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal 解码 Marshal 的结果
+func (c *GobConn) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
 // Close 关闭连接
 func (c *GobConn) Close() error {
-	return c.conn.Close()
+	return c.framer.Close()
 }
 
 // NewGobConn 构造函数
-func NewGobConn(conn io.ReadWriteCloser) Conn {
-	buf := bufio.NewWriter(conn)
+func NewGobConn(framer *Framer) Conn {
+	rbuf, wbuf := new(bytes.Buffer), new(bytes.Buffer)
 	return &GobConn{
-		conn:    conn,
-		buf:     buf,
-		decoder: gob.NewDecoder(conn),
-		encoder: gob.NewEncoder(buf),
+		framer:  framer,
+		rbuf:    rbuf,
+		wbuf:    wbuf,
+		decoder: gob.NewDecoder(rbuf), // bytes.Buffer 实现了 io.ByteReader，gob 不会再额外包装一层缓冲
+		encoder: gob.NewEncoder(wbuf),
 	}
 }
 
 // 将nil转换为*GobConn类型，然后再转换为Conn接口，如果转换失败，说明*GobConn没有实现Conn接口的所有方法。
 var _ Conn = (*GobConn)(nil)
+var _ ValueCodec = (*GobConn)(nil)
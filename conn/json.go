@@ -1,45 +1,66 @@
 package conn
 
 import (
-	"bufio"
-	"encoding/gob"
-	"io"
+	"encoding/json"
 	"log"
 )
 
 // json消息的编解码器
+// Header 和 Body 各自编码为一个 JSON 文本，作为一帧的内容发送，便于非 Go 语言的客户端（Python、Node 等）解析：
+// | 4 字节长度 | 1 字节 flags | {"ServiceMethod":"Foo.Sum","Seq":1,"Error":""} |
+// | 4 字节长度 | 1 字节 flags | {"Num1":1,"Num2":2} |
 
 type JsonConn struct {
-	conn    io.ReadWriteCloser // 连接实例，由构造函数传入
-	buf     *bufio.Writer      // 防止阻塞而创建的带缓冲的Writer（能提升性能）
-	encoder *gob.Encoder       // 编码
-	decoder *gob.Decoder       // 解码
+	framer *Framer // 帧的读写，由构造函数传入
 }
 
 // ReadHeader 对头部进行解码
 func (c *JsonConn) ReadHeader(header *Header) error {
-	return c.decoder.Decode(header)
+	payload, err := c.framer.ReadFrame()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(payload, header)
 }
 
 // ReadBody 对消息体进行解码
+// body 为 nil 时表示调用方不关心消息体，整帧读出后直接丢弃；空帧代表没有消息体
 func (c *JsonConn) ReadBody(body interface{}) error {
-	return c.decoder.Decode(body)
+	payload, err := c.framer.ReadFrame()
+	if err != nil || body == nil || len(payload) == 0 {
+		return err
+	}
+	return json.Unmarshal(payload, body)
+}
+
+// writeFrame 编码 v 并写出一帧，v 为 nil 时写出一个空帧
+func (c *JsonConn) writeFrame(v interface{}) error {
+	var data []byte
+	if v != nil {
+		var err error
+		if data, err = json.Marshal(v); err != nil {
+			return err
+		}
+	}
+	return c.framer.WriteFrame(data)
 }
 
 // Write 写数据
 func (c *JsonConn) Write(header *Header, body interface{}) (err error) {
 	defer func() {
-		_ = c.buf.Flush()
+		if err == nil {
+			err = c.framer.Flush()
+		}
 		if err != nil {
 			_ = c.Close()
 		}
 	}()
 
-	if err := c.encoder.Encode(header); err != nil {
+	if err := c.writeFrame(header); err != nil {
 		log.Println("FastRPC conn: json error while encoding header:", err)
 		return err
 	}
-	if err := c.encoder.Encode(body); err != nil {
+	if err := c.writeFrame(body); err != nil {
 		log.Println("FastRPC conn: json error while encoding body:", err)
 		return err
 	}
@@ -47,21 +68,26 @@ func (c *JsonConn) Write(header *Header, body interface{}) (err error) {
 	return nil
 }
 
+// Marshal 独立编码 v，用于批量调用
+func (c *JsonConn) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Unmarshal 解码 Marshal 的结果
+func (c *JsonConn) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
 // Close 关闭连接
 func (c *JsonConn) Close() error {
-	return c.conn.Close()
+	return c.framer.Close()
 }
 
 // NewJsonConn 构造函数
-func NewJsonConn(conn io.ReadWriteCloser) Conn {
-	buf := bufio.NewWriter(conn)
-	return &JsonConn{
-		conn:    conn,
-		buf:     buf,
-		decoder: gob.NewDecoder(conn),
-		encoder: gob.NewEncoder(buf),
-	}
+func NewJsonConn(framer *Framer) Conn {
+	return &JsonConn{framer: framer}
 }
 
-// // 将nil转换为*JsonConn类型，然后再转换为Conn接口，如果转换失败，说明*JsonConn没有实现Conn接口的所有方法。
+// 将nil转换为*JsonConn类型，然后再转换为Conn接口，如果转换失败，说明*JsonConn没有实现Conn接口的所有方法。
 var _ Conn = (*JsonConn)(nil)
+var _ ValueCodec = (*JsonConn)(nil)
@@ -0,0 +1,58 @@
+package conn
+
+import "fmt"
+
+/*
+协议版本与能力协商
+客户端在 Option 中携带自己的协议版本 Version 和支持的能力集合 Capabilities，
+服务端选择双方都支持的最高版本，并将能力集合与自己支持的能力取交集，然后在回复的 Option 中返回。
+这样在滚动升级期间，新旧版本的客户端和服务端可以共存，双方只使用协商后的能力。
+*/
+
+const (
+	ProtocolVersion    = 1 // 当前实现的协议版本
+	MinProtocolVersion = 1 // 能够兼容的最低协议版本
+)
+
+// Capability 表示一项可选的协议能力，多项能力通过按位或组合在一起
+type Capability uint32
+
+const (
+	CapCompression  Capability = 1 << iota // 消息体压缩
+	CapMetadata                            // Header 中携带元数据
+	CapStreaming                           // 流式调用
+	CapCancellation                        // 客户端取消请求
+	CapFlowControl                         // 连接级流量控制，客户端等待服务端归还额度后再发送新的请求
+	CapOneWay                              // 单向调用，服务端不回复
+	CapBatch                               // 批量调用
+	CapPush                                // 服务端推送
+	CapReverse                             // 服务端调用客户端注册的服务
+)
+
+// CapNone 不启用任何可选能力。Option.Capabilities 为 0 时 ParseOptions 使用默认的能力集合，
+// 需要关闭所有能力时使用 CapNone，它不对应任何能力，协商时被去掉
+const CapNone Capability = 1 << 31
+
+// SupportedCapabilities 当前实现所支持的能力集合
+const SupportedCapabilities = CapCompression | CapMetadata | CapStreaming | CapCancellation | CapFlowControl | CapOneWay | CapBatch | CapPush | CapReverse
+
+// Has 判断是否包含能力 f
+func (c Capability) Has(f Capability) bool {
+	return c&f == f
+}
+
+// Negotiate 根据对端发来的 Option 和本端支持的能力 caps，协商出双方共同使用的版本和能力，结果写回 opt
+func Negotiate(opt *Option, caps Capability) error {
+	if opt.Version < MinProtocolVersion {
+		return fmt.Errorf("FastRPC conn: unsupported protocol version %d, expect %d~%d",
+			opt.Version, MinProtocolVersion, ProtocolVersion)
+	}
+	if opt.Version > ProtocolVersion {
+		opt.Version = ProtocolVersion
+	}
+	opt.Capabilities &= caps
+	if !opt.Capabilities.Has(CapCompression) || newCompressorFuncMap[opt.Compression] == nil {
+		opt.Compression = CompressNone
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+package conn
+
+import "testing"
+
+func TestNegotiate(t *testing.T) {
+	t.Run("newer client", func(t *testing.T) {
+		opt := &Option{Version: ProtocolVersion + 1, Capabilities: CapMetadata | CapStreaming}
+		err := Negotiate(opt, CapMetadata|CapCompression)
+		_assert(err == nil && opt.Version == ProtocolVersion, "expect downgrade to version %d", ProtocolVersion)
+		_assert(opt.Capabilities == CapMetadata, "expect the intersection of capabilities, but got %b", opt.Capabilities)
+	})
+	t.Run("unsupported", func(t *testing.T) {
+		opt := &Option{Version: MinProtocolVersion - 1}
+		_assert(Negotiate(opt, SupportedCapabilities) != nil, "expect an unsupported version error")
+	})
+	t.Run("none", func(t *testing.T) {
+		opt, _ := ParseOptions(&Option{})
+		_assert(opt.Capabilities == SupportedCapabilities, "expect the default capabilities for 0, but got %b", opt.Capabilities)
+		opt, _ = ParseOptions(&Option{Capabilities: CapNone})
+		_assert(Negotiate(opt, SupportedCapabilities) == nil && opt.Capabilities == 0,
+			"expect no capabilities for CapNone, but got %b", opt.Capabilities)
+	})
+}
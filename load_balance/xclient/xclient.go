@@ -2,6 +2,7 @@ package xclient
 
 import (
 	"context"
+	"crypto/tls"
 	"fastRPC/client"
 	"fastRPC/conn"
 	"io"
@@ -16,6 +17,8 @@ type XClient struct {
 	opt     *conn.Option              // 协议选项 Option
 	mu      sync.Mutex                // protect following
 	clients map[string]*client.Client // 保存创建成功的 Client 实例
+	dialer  client.Dialer             // 创建 Client 时使用，保存了 Use 注册的拦截器
+	retry   client.RetryPolicies      // 按方法配置的重试策略
 }
 
 var _ io.Closer = (*XClient)(nil)
@@ -35,6 +38,32 @@ func (xc *XClient) Close() error {
 	return nil
 }
 
+// Use 注册客户端拦截器，拦截器对已经创建和之后创建的所有 Client 生效
+// Broadcast 时每个服务实例上的调用都会分别经过拦截器
+func (xc *XClient) Use(interceptors ...client.UnaryClientInterceptor) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.dialer.Interceptors = append(xc.dialer.Interceptors, interceptors...)
+	for _, c := range xc.clients {
+		c.Use(interceptors...)
+	}
+}
+
+// SetTLSConfig 设置连接 tls@ 地址的服务实例时使用的 TLS 配置，只对之后创建的 Client 生效
+func (xc *XClient) SetTLSConfig(config *tls.Config) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.dialer.TLSConfig = config
+}
+
+// SetRetryPolicies 设置按方法配置的重试策略，Call 失败后通过 Discovery 重新选择服务实例重试
+// Broadcast 需要调用所有的实例，不会重试
+func (xc *XClient) SetRetryPolicies(policies client.RetryPolicies) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.retry = policies
+}
+
 // =========================================================
 
 func (xc *XClient) dial(rpcAddr string) (*client.Client, error) {
@@ -52,7 +81,7 @@ func (xc *XClient) dial(rpcAddr string) (*client.Client, error) {
 	// 没有返回缓存的 Client，则说明需要创建新的 Client，缓存并返回
 	if c == nil {
 		var err error
-		c, err = client.XDial(rpcAddr, xc.opt)
+		c, err = xc.dialer.XDial(rpcAddr, xc.opt)
 		if err != nil {
 			return nil, err
 		}
@@ -73,11 +102,57 @@ func (xc *XClient) call(rpcAddr string, ctx context.Context, serviceMethod strin
 // Call invokes the named function, waits for it to complete, and returns its error status.
 // xc will choose a proper server.
 func (xc *XClient) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	xc.mu.Lock()
+	policy := xc.retry.Lookup(serviceMethod)
+	xc.mu.Unlock()
+	if policy == nil {
+		rpcAddr, err := xc.d.Get(xc.mode)
+		if err != nil {
+			return err
+		}
+		return xc.call(rpcAddr, ctx, serviceMethod, args, reply)
+	}
+
+	var last string
+	return policy.Do(ctx, func(int) error {
+		rpcAddr, err := xc.pick(last)
+		if err != nil {
+			return err
+		}
+		last = rpcAddr
+		return xc.call(rpcAddr, ctx, serviceMethod, args, reply)
+	})
+}
+
+// Notify 通过 Discovery 选择一个服务实例发送单向调用，见 client.Client.Notify
+func (xc *XClient) Notify(ctx context.Context, serviceMethod string, args interface{}) error {
 	rpcAddr, err := xc.d.Get(xc.mode)
 	if err != nil {
 		return err
 	}
-	return xc.call(rpcAddr, ctx, serviceMethod, args, reply)
+	c, err := xc.dial(rpcAddr)
+	if err != nil {
+		return err
+	}
+	return c.Notify(ctx, serviceMethod, args)
+}
+
+// pick 通过 Discovery 选择一个服务实例，避开上一次失败的实例 exclude，只有一个实例时仍然会返回 exclude
+func (xc *XClient) pick(exclude string) (string, error) {
+	rpcAddr, err := xc.d.Get(xc.mode)
+	if err != nil || rpcAddr != exclude {
+		return rpcAddr, err
+	}
+	servers, err := xc.d.GetAll()
+	if err != nil {
+		return "", err
+	}
+	for _, rpcAddr := range servers {
+		if rpcAddr != exclude {
+			return rpcAddr, nil
+		}
+	}
+	return exclude, nil
 }
 
 // Broadcast invokes the named function for every server registered in discovery
@@ -96,6 +171,7 @@ func (xc *XClient) Broadcast(ctx context.Context, serviceMethod string, args, re
 	var e error
 	replyDone := reply == nil // if reply is nil, don't need to set value
 	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
 	for _, rpcAddr := range servers {
 		wg.Add(1)
@@ -69,7 +69,8 @@ func StartClient() {
 		go func(i int) {
 			defer wg.Done()
 
-			ctx, _ := context.WithTimeout(context.Background(), time.Second)
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
 			args := &Args{Num1: i, Num2: i * i}
 			var reply int
 			if err := c.Call(ctx, "Foo.Sum", args, &reply); err != nil {
@@ -0,0 +1,158 @@
+package metadata
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+/*
+元数据（metadata）随请求和响应的 Header 一起传输，用于携带 trace id、鉴权 token、租户、调用方名称等与业务参数无关的信息。
+1. 客户端：通过 NewOutgoingContext / AppendToOutgoingContext 将元数据附加到传给 Client.Call 的 ctx 中，
+   通过 WithReplyHolder 取得服务端设置的响应元数据；
+2. 服务端：通过 FromIncomingContext 从传给服务方法的 ctx 中读取请求的元数据，通过 SetReply 设置响应的元数据。
+key 统一转换为小写。
+*/
+
+// MD 元数据，key 不区分大小写
+type MD map[string]string
+
+// Pairs 通过 key, value, key, value ... 的形式创建 MD，参数个数为奇数时 panic
+func Pairs(kv ...string) MD {
+	if len(kv)%2 == 1 {
+		panic(fmt.Sprintf("metadata: Pairs got the odd number of input pairs: %d", len(kv)))
+	}
+	md := make(MD, len(kv)/2)
+	for i := 0; i < len(kv); i += 2 {
+		md.Set(kv[i], kv[i+1])
+	}
+	return md
+}
+
+// Get 返回 key 对应的值
+func (md MD) Get(key string) string {
+	return md[strings.ToLower(key)]
+}
+
+// Set 设置 key 对应的值
+func (md MD) Set(key, value string) {
+	md[strings.ToLower(key)] = value
+}
+
+// normalize 将 key 转换为小写，md 中的 key 已经都是小写时直接返回 md
+func normalize(md MD) MD {
+	for k := range md {
+		if k != strings.ToLower(k) {
+			out := make(MD, len(md))
+			for k, v := range md {
+				out.Set(k, v)
+			}
+			return out
+		}
+	}
+	return md
+}
+
+// Copy 返回 md 的拷贝
+func (md MD) Copy() MD {
+	out := make(MD, len(md))
+	for k, v := range md {
+		out[k] = v
+	}
+	return out
+}
+
+// ============================================================
+
+type outgoingKey struct{}
+type incomingKey struct{}
+type replyKey struct{}
+type replyHolderKey struct{}
+
+// NewOutgoingContext 返回附加了元数据 md 的 ctx，客户端发送请求时会将其写入请求的 Header
+func NewOutgoingContext(ctx context.Context, md MD) context.Context {
+	return context.WithValue(ctx, outgoingKey{}, normalize(md))
+}
+
+// AppendToOutgoingContext 在 ctx 已有的元数据基础上追加 key, value 对
+func AppendToOutgoingContext(ctx context.Context, kv ...string) context.Context {
+	md, _ := FromOutgoingContext(ctx)
+	md = md.Copy()
+	for k, v := range Pairs(kv...) {
+		md[k] = v
+	}
+	return NewOutgoingContext(ctx, md)
+}
+
+// FromOutgoingContext 返回 ctx 中附加的待发送的元数据
+func FromOutgoingContext(ctx context.Context) (MD, bool) {
+	md, ok := ctx.Value(outgoingKey{}).(MD)
+	return md, ok
+}
+
+// WithReplyHolder 返回附加了 md 的 ctx，使用该 ctx 的 Client.Call 返回时，服务端设置的响应元数据被写入 md
+//
+//	var reply metadata.MD
+//	err := c.Call(metadata.WithReplyHolder(ctx, &reply), "Foo.Sum", args, &sum)
+//	reply.Get("cost")
+func WithReplyHolder(ctx context.Context, md *MD) context.Context {
+	return context.WithValue(ctx, replyHolderKey{}, md)
+}
+
+// ReplyHolderFromContext 返回通过 WithReplyHolder 附加的 md，由客户端在收到响应时调用
+func ReplyHolderFromContext(ctx context.Context) (*MD, bool) {
+	md, ok := ctx.Value(replyHolderKey{}).(*MD)
+	return md, ok
+}
+
+// replyHolder 保存服务方法设置的响应元数据，服务方法可能在其他协程中调用 SetReply，因此需要加锁
+type replyHolder struct {
+	mu sync.Mutex
+	md MD
+}
+
+// NewIncomingContext 返回附加了请求元数据 md 的 ctx，由服务端在调用服务方法之前创建
+// md 来自对端的 Header，key 不一定是小写，这里统一转换
+func NewIncomingContext(ctx context.Context, md MD) context.Context {
+	ctx = context.WithValue(ctx, incomingKey{}, normalize(md))
+	return context.WithValue(ctx, replyKey{}, &replyHolder{})
+}
+
+// FromIncomingContext 返回请求携带的元数据
+func FromIncomingContext(ctx context.Context) (MD, bool) {
+	md, ok := ctx.Value(incomingKey{}).(MD)
+	return md, ok
+}
+
+// SetReply 设置响应的元数据，多次调用时合并
+func SetReply(ctx context.Context, md MD) error {
+	holder, ok := ctx.Value(replyKey{}).(*replyHolder)
+	if !ok {
+		return errors.New("metadata: failed to set reply metadata: ctx is not an incoming context")
+	}
+	holder.mu.Lock()
+	defer holder.mu.Unlock()
+	if holder.md == nil {
+		holder.md = make(MD, len(md))
+	}
+	for k, v := range md {
+		holder.md.Set(k, v)
+	}
+	return nil
+}
+
+// ReplyFromIncomingContext 返回服务方法通过 SetReply 设置的响应元数据，由服务端在发送响应时调用
+func ReplyFromIncomingContext(ctx context.Context) MD {
+	holder, ok := ctx.Value(replyKey{}).(*replyHolder)
+	if !ok {
+		return nil
+	}
+	holder.mu.Lock()
+	defer holder.mu.Unlock()
+	if holder.md == nil {
+		return nil
+	}
+	return holder.md.Copy()
+}
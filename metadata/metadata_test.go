@@ -0,0 +1,45 @@
+package metadata
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func _assert(condition bool, msg string, v ...interface{}) {
+	if !condition {
+		panic(fmt.Sprintf("assertion failed: "+msg, v...))
+	}
+}
+
+func TestOutgoingContext(t *testing.T) {
+	ctx := NewOutgoingContext(context.Background(), Pairs("Trace-Id", "t1"))
+	ctx2 := AppendToOutgoingContext(ctx, "caller", "svc-a")
+
+	md, ok := FromOutgoingContext(ctx2)
+	_assert(ok && md.Get("trace-id") == "t1" && md.Get("Caller") == "svc-a", "unexpected metadata %v", md)
+	md, _ = FromOutgoingContext(ctx)
+	_assert(len(md) == 1, "AppendToOutgoingContext shouldn't modify the parent metadata, got %v", md)
+
+	md, _ = FromOutgoingContext(NewOutgoingContext(context.Background(), MD{"Trace-Id": "t1"}))
+	_assert(md.Get("trace-id") == "t1", "expect lowercase keys, but got %v", md)
+}
+
+func TestIncomingContext(t *testing.T) {
+	ctx := NewIncomingContext(context.Background(), Pairs("tenant", "t1"))
+	md, ok := FromIncomingContext(ctx)
+	_assert(ok && md.Get("tenant") == "t1", "unexpected metadata %v", md)
+
+	_assert(ReplyFromIncomingContext(ctx) == nil, "expect no reply metadata")
+	_ = SetReply(ctx, Pairs("a", "1"))
+	_ = SetReply(ctx, Pairs("b", "2"))
+	reply := ReplyFromIncomingContext(ctx)
+	_assert(reply.Get("a") == "1" && reply.Get("b") == "2", "unexpected reply metadata %v", reply)
+
+	_assert(SetReply(context.Background(), Pairs("a", "1")) != nil, "expect an error for a non-incoming context")
+
+	// 对端发来的 key 不一定是小写
+	ctx = NewIncomingContext(context.Background(), MD{"Trace-Id": "t1"})
+	md, _ = FromIncomingContext(ctx)
+	_assert(md.Get("trace-id") == "t1", "expect lowercase keys, but got %v", md)
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"fastRPC/conn"
+	"fastRPC/service"
+	"sync"
+	"time"
+)
+
+/*
+批量请求（格式见 conn/batch.go）
+批量请求整体占用一个请求的额度，共享同一个 ctx（deadline、元数据和取消）。
+其中的每一项都像普通请求一样通过 findService 查找服务方法，并发地经过拦截器和 Service.CallContext 执行，
+因此每一项都会被计入 MethodType.NumCalls。并发执行的协程数不超过开始时连接窗口剩余的额度（至少一个），
+这样一个批量请求不会突破连接级流量控制对协程数的限制。所有项都完成后一次性回复，
+ctx 先超时的话，未完成的项回复超时错误；客户端取消或断开时不回复。
+*/
+
+// readBatch 读取批量请求的消息体
+func (server *Server) readBatch(cc conn.Conn, req *request) error {
+	if _, ok := cc.(conn.ValueCodec); !ok {
+		_ = cc.ReadBody(nil)
+		return conn.NewError(conn.Unimplemented, "FastRPC server: the codec does not support batch calls")
+	}
+	if err := cc.ReadBody(&req.batch); err != nil {
+		return conn.Errorf(conn.InvalidArgument, "FastRPC server: read batch error: %v", err)
+	}
+	if len(req.batch) > conn.MaxBatchSize {
+		return conn.Errorf(conn.ResourceExhausted, "FastRPC server: too many calls in a batch: %d > %d", len(req.batch), conn.MaxBatchSize)
+	}
+	return nil
+}
+
+// newBatchRequest 为批量请求中的一项构造 request，与批量请求共享 ctx
+func (server *Server) newBatchRequest(vc conn.ValueCodec, req *request, item conn.BatchItem) (*request, error) {
+	sub := &request{
+		header: &conn.Header{ServiceMethod: item.ServiceMethod, Seq: req.header.Seq},
+		ctx:    req.ctx,
+		cancel: req.cancel,
+	}
+	var err error
+	if sub.svc, sub.mType, err = server.findService(item.ServiceMethod); err != nil {
+		return nil, err
+	}
+	if sub.mType.StreamType() != service.Unary {
+		return nil, conn.NewError(conn.Unimplemented, "FastRPC server: streaming method can't be called in a batch: "+item.ServiceMethod)
+	}
+
+	sub.argv, sub.replyv = sub.mType.NewArgv(), sub.mType.NewReplyv()
+	if len(item.Args) > 0 {
+		if err := vc.Unmarshal(item.Args, argvPointer(sub.argv)); err != nil {
+			return nil, conn.Errorf(conn.InvalidArgument, "FastRPC server: read body error: %v", err)
+		}
+	}
+	return sub, nil
+}
+
+// handleBatch 由若干个协程并发执行批量请求中的每一项，全部完成后回复，执行的协程记录在 handlers 中
+func (server *Server) handleBatch(sc *serverConn, req *request, wg, handlers *sync.WaitGroup, timeout time.Duration) {
+	defer wg.Done()
+	defer req.cancel()
+
+	vc := sc.cc.(conn.ValueCodec)
+	results := make([]conn.BatchResult, len(req.batch))
+	subs := make([]*request, len(req.batch))
+	called := make([]chan error, len(req.batch))
+	items := make(chan int, len(req.batch))
+	for i, item := range req.batch {
+		sub, err := server.newBatchRequest(vc, req, item)
+		if err != nil {
+			results[i].SetError(err)
+			continue
+		}
+		subs[i], called[i] = sub, make(chan error, 1)
+		items <- i
+	}
+	close(items)
+
+	workers := sc.spare() + 1
+	if workers > len(items) {
+		workers = len(items)
+	}
+	handlers.Add(workers)
+	for n := 0; n < workers; n++ {
+		go func() {
+			defer handlers.Done()
+			for i := range items {
+				// ctx 结束后不再执行剩余的项，它们的结果不会被使用
+				if err := req.ctx.Err(); err != nil {
+					called[i] <- err
+					continue
+				}
+				called[i] <- server.invoke(subs[i])
+			}
+		}()
+	}
+
+	for i := range called {
+		if called[i] == nil {
+			continue
+		}
+		var err error
+		select {
+		case err = <-called[i]:
+		case <-req.ctx.Done():
+			err = req.ctx.Err()
+			if err == context.Canceled {
+				return
+			}
+		}
+		if err = handleError(req.ctx, err, timeout); err != nil {
+			results[i].SetError(err)
+			continue
+		}
+		if results[i].Reply, err = vc.Marshal(subs[i].replyv.Interface()); err != nil {
+			results[i].SetError(conn.Errorf(conn.Internal, "FastRPC server: encode reply error: %v", err))
+		}
+	}
+
+	req.header.Metadata = replyMetadata(req.ctx)
+	server.sendResponse(sc.cc, req.header, results, sc.sending)
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"fastRPC/conn"
+	"log"
+)
+
+/*
+连接级流量控制
+每个连接上同时处理的请求数不超过窗口大小（默认 conn.DefaultConnWindow，可以通过 SetMaxConcurrentRequests 修改），
+窗口大小随回复的 Option.ConnWindow 告知客户端。客户端每发送一个请求消耗一个额度，额度用完后等待，
+服务端每回复一部分请求就发送 Seq 为 0 的 KindWindowUpdate 归还额度：
+
+	server <- | Header{Kind: KindWindowUpdate, Window: n} | (empty) |
+
+因此即使服务方法处理得很慢，每个连接上的协程数和客户端未完成的请求数也都是有上限的。
+请求超时或被取消后，服务端会立即回复，但要等执行服务方法的协程退出之后才归还额度，
+因此不接收 context.Context、无法被打断的服务方法同样计入窗口。
+没有协商 CapFlowControl 的客户端不会等待额度，超出窗口的请求直接回复 ResourceExhausted。
+流内的消息另有各自的窗口，见 stream.go。
+*/
+
+var errTooManyRequests error = conn.NewError(conn.ResourceExhausted, "FastRPC server: too many requests in progress")
+
+// SetMaxConcurrentRequests 设置每个连接上同时处理的最大请求数，只对之后建立的连接生效，n <= 0 时使用默认值
+func (server *Server) SetMaxConcurrentRequests(n int) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	server.maxRequests = n
+}
+
+// connWindow 新连接使用的请求窗口
+func (server *Server) connWindow() int {
+	server.mu.RLock()
+	defer server.mu.RUnlock()
+	if server.maxRequests <= 0 {
+		return conn.DefaultConnWindow
+	}
+	return server.maxRequests
+}
+
+// spare 窗口中剩余的额度，即还可以同时处理的请求数
+func (sc *serverConn) spare() int {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.active >= sc.window {
+		return 0
+	}
+	return sc.window - sc.active
+}
+
+// grant 一个请求已经回复，归还它的额度，累计到窗口的 1/4 时返回需要通知客户端的额度，调用方需要持有 sc.mu
+func (sc *serverConn) grant() int {
+	if !sc.flow {
+		return 0
+	}
+	sc.unacked++
+	if sc.unacked < sc.window/4 {
+		return 0
+	}
+	n := sc.unacked
+	sc.unacked = 0
+	return n
+}
+
+// sendWindow 通知客户端归还 n 个额度，写操作可能阻塞，调用方不能持有 sc.mu，否则读循环中的 acquire 也会被阻塞
+func (sc *serverConn) sendWindow(n int) {
+	if n == 0 {
+		return
+	}
+	sc.sending.Lock()
+	err := sc.cc.Write(&conn.Header{Kind: conn.KindWindowUpdate, Window: uint32(n)}, nil)
+	sc.sending.Unlock()
+	if err != nil {
+		log.Println("FastRPC server: send window update error:", err)
+	}
+}
+
+// reject 直接回复的请求（出错、被拒绝）同样需要归还额度
+func (sc *serverConn) reject() {
+	sc.mu.Lock()
+	n := sc.grant()
+	sc.mu.Unlock()
+	sc.sendWindow(n)
+}
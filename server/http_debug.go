@@ -15,11 +15,12 @@ const debugText = `<html>
 	Service <b>{{.Name}}</b>
 	<hr>
 		<table>
-		<th align=center>Method</th><th align=center>Calls</th>
+		<th align=center>Method</th><th align=center>Calls</th><th align=center>Panics</th>
 		{{range $name, $mType := .Method}}
 			<tr>
-			<td align=left font=fixed>{{$name}}({{$mType.ArgType}}, {{$mType.ReplyType}}) error</td>
+			<td align=left font=fixed>{{$name}}({{if $mType.HasContext}}context.Context, {{end}}{{$mType.ArgType}}, {{if $mType.ReplyType}}{{$mType.ReplyType}}{{else}}service.ServerStream{{end}}) error</td>
 			<td align=center>{{$mType.NumCalls}}</td>
+			<td align=center>{{$mType.NumPanics}}</td>
 			</tr>
 		{{end}}
 		</table>
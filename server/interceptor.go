@@ -0,0 +1,97 @@
+package server
+
+import (
+	"context"
+	"fastRPC/conn"
+	"fastRPC/metadata"
+	"fastRPC/service"
+	"log"
+	"reflect"
+)
+
+/*
+服务端拦截器（中间件）
+日志、鉴权、统计、panic 处理等逻辑与具体的服务方法无关，通过拦截器统一包装每一次调用，
+避免在每个服务方法中重复编写。拦截器按照注册的顺序嵌套，先注册的在外层：
+
+	server.Use(logging, auth)
+	logging -> auth -> service method
+*/
+
+// UnaryServerInfo 一次调用的信息，Reply 在 handler 返回之后才被填充
+// 流式方法同样经过拦截器，handler 在流结束时返回，Reply 为 nil
+type UnaryServerInfo struct {
+	ServiceMethod string      // format "<service>.<method>"
+	Service       string      // name of service
+	Method        string      // name of method
+	Metadata      metadata.MD // metadata of request
+	Reply         interface{} // reply of the call
+	Peer          *Peer       // connection of the request, e.g. the identity of TLS client certificate
+}
+
+// UnaryHandler 调用服务方法，拦截器需要调用 handler 才能继续执行后续的拦截器和服务方法
+type UnaryHandler func(ctx context.Context, args interface{}) error
+
+// UnaryServerInterceptor 拦截器，可以在 handler 前后增加逻辑，也可以不调用 handler 直接返回错误
+type UnaryServerInterceptor func(ctx context.Context, info *UnaryServerInfo, args interface{}, handler UnaryHandler) error
+
+// Use 注册拦截器，拦截器对之后到达的所有请求生效
+func (server *Server) Use(interceptors ...UnaryServerInterceptor) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	server.interceptors = append(server.interceptors, interceptors...)
+}
+
+// Use registers interceptors to the DefaultServer.
+func Use(interceptors ...UnaryServerInterceptor) { DefaultServer.Use(interceptors...) }
+
+// chainInterceptors 将拦截器和服务方法组合为一个 handler，interceptors[0] 在最外层
+func chainInterceptors(interceptors []UnaryServerInterceptor, info *UnaryServerInfo, handler UnaryHandler) UnaryHandler {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], handler
+		handler = func(ctx context.Context, args interface{}) error {
+			return interceptor(ctx, info, args, next)
+		}
+	}
+	return handler
+}
+
+// invoke 经过所有的拦截器调用服务方法
+// 服务方法的 panic 由 Service 恢复，拦截器中的 panic 在这里恢复，同样以 *service.PanicError 的形式返回
+func (server *Server) invoke(req *request) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			req.mType.IncPanics()
+			perr := service.NewPanicError(req.header.ServiceMethod, r)
+			log.Printf("FastRPC server: %v\n%s", perr, perr.Stack)
+			err = perr
+		}
+	}()
+
+	server.mu.RLock()
+	interceptors := server.interceptors
+	server.mu.RUnlock()
+
+	md, _ := metadata.FromIncomingContext(req.ctx)
+	info := &UnaryServerInfo{
+		ServiceMethod: req.header.ServiceMethod,
+		Service:       req.svc.GetName(),
+		Method:        req.mType.Name(),
+		Metadata:      md,
+	}
+	info.Peer, _ = PeerFromContext(req.ctx)
+	if req.replyv.IsValid() {
+		info.Reply = req.replyv.Interface()
+	}
+	handler := func(ctx context.Context, args interface{}) error {
+		argv := reflect.ValueOf(args)
+		if !argv.IsValid() || argv.Type() != req.mType.ArgType {
+			return conn.Errorf(conn.InvalidArgument, "FastRPC server: wrong argument type %T, expect %s", args, req.mType.ArgType)
+		}
+		if req.mType.StreamType().IsServerStream() {
+			return req.svc.CallStream(ctx, req.mType, argv, contextStream{ServerStream: req.stream, ctx: ctx})
+		}
+		return req.svc.CallContext(ctx, req.mType, argv, req.replyv)
+	}
+	return chainInterceptors(interceptors, info, handler)(req.ctx, req.argv.Interface())
+}
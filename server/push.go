@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"fastRPC/conn"
+	"log"
+)
+
+/*
+服务端推送
+协商了 CapPush 的连接上，服务端可以随时发送 KindPush 消息，ServiceMethod 为主题（topic），消息体即推送的内容：
+
+	server <- | Header{ServiceMethod: "cache", Kind: KindPush} | Msg |
+
+服务方法可以通过 Push 向发起请求的连接推送消息，例如在回复之前告知处理进度；
+Server.Publish 则把消息推送给所有订阅了该主题的连接，例如广播缓存失效的通知。
+Publish 不直接写连接，而是把消息放入每个连接各自的发送队列（最多 pushQueueSize 条），由该连接的 pushLoop 依次发送，
+因此一个不读取数据的订阅者不会阻塞广播和其他连接，它的队列满了之后新的消息直接被丢弃。
+客户端通过 KindSubscribe/KindUnsubscribe 订阅和取消订阅主题（见 client.Client.OnPush），连接断开后订阅随之失效，
+每个连接最多订阅 conn.MaxTopics 个主题。
+推送不占用流量控制的额度，也不保证送达：连接断开、写入失败或者队列已满时消息被丢弃。
+*/
+
+var errPushNotNegotiated error = conn.NewError(conn.FailedPrecondition, "FastRPC server: push is not negotiated")
+
+// pushQueueSize 每个连接的发送队列中最多缓存的推送消息数
+const pushQueueSize = 64
+
+// pushMsg 发送队列中的一条推送消息
+type pushMsg struct {
+	topic string
+	msg   interface{}
+}
+
+type serverConnKey struct{}
+
+// withServerConn 将连接保存在 ctx 中，服务方法通过 Push 向该连接推送消息
+func withServerConn(ctx context.Context, sc *serverConn) context.Context {
+	return context.WithValue(ctx, serverConnKey{}, sc)
+}
+
+// Push 向 ctx 所属的请求的连接推送一条 topic 主题的消息，ctx 必须是传给服务方法的 ctx 或由它派生
+// 客户端没有订阅该主题时同样会收到消息，没有注册处理函数的消息被客户端丢弃
+func Push(ctx context.Context, topic string, msg interface{}) error {
+	sc, ok := ctx.Value(serverConnKey{}).(*serverConn)
+	if !ok {
+		return conn.NewError(conn.FailedPrecondition, "FastRPC server: no connection in context")
+	}
+	return sc.push(topic, msg)
+}
+
+// Publish 将一条 topic 主题的消息推送给所有订阅了该主题的连接，返回消息进入了发送队列的连接数
+// Publish 不会阻塞，订阅者读取得太慢、发送队列已满时，该连接上的这条消息被丢弃
+func (server *Server) Publish(topic string, msg interface{}) int {
+	server.mu.RLock()
+	conns := make([]*serverConn, 0, len(server.conns))
+	for sc := range server.conns {
+		conns = append(conns, sc)
+	}
+	server.mu.RUnlock()
+
+	n := 0
+	for _, sc := range conns {
+		if !sc.subscribed(topic) {
+			continue
+		}
+		if !sc.publish(topic, msg) {
+			log.Printf("FastRPC server: publish %s: push queue is full, drop the message", topic)
+			continue
+		}
+		n++
+	}
+	return n
+}
+
+// Publish publishes a message to the connections subscribed to topic of the DefaultServer.
+func Publish(topic string, msg interface{}) int { return DefaultServer.Publish(topic, msg) }
+
+// push 发送一条推送消息
+func (sc *serverConn) push(topic string, msg interface{}) error {
+	if !sc.pushable {
+		return errPushNotNegotiated
+	}
+	sc.sending.Lock()
+	defer sc.sending.Unlock()
+	return sc.cc.Write(&conn.Header{ServiceMethod: topic, Kind: conn.KindPush}, msg)
+}
+
+// publish 将消息放入发送队列，没有协商 CapPush 或者队列已满时返回 false
+func (sc *serverConn) publish(topic string, msg interface{}) bool {
+	select {
+	case sc.pushq <- pushMsg{topic: topic, msg: msg}:
+		return true
+	default:
+		return false
+	}
+}
+
+// pushLoop 依次发送队列中的消息，直到连接断开
+func (sc *serverConn) pushLoop(done <-chan struct{}) {
+	for {
+		select {
+		case m := <-sc.pushq:
+			if err := sc.push(m.topic, m.msg); err != nil {
+				log.Println("FastRPC server: publish error:", err)
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// subscribe 订阅或者取消订阅主题，空的主题和超过 conn.MaxTopics 的订阅被忽略
+func (sc *serverConn) subscribe(topic string, on bool) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if !on {
+		delete(sc.topics, topic)
+		return
+	}
+	if _, ok := sc.topics[topic]; ok || topic == "" {
+		return
+	}
+	if len(sc.topics) >= conn.MaxTopics {
+		log.Printf("FastRPC server: too many topics subscribed, ignore %s", topic)
+		return
+	}
+	if sc.topics == nil {
+		sc.topics = make(map[string]struct{})
+	}
+	sc.topics[topic] = struct{}{}
+}
+
+func (sc *serverConn) subscribed(topic string) bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	_, ok := sc.topics[topic]
+	return ok
+}
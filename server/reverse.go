@@ -0,0 +1,141 @@
+package server
+
+import (
+	"context"
+	"fastRPC/conn"
+	"fastRPC/metadata"
+	"time"
+)
+
+/*
+反向调用
+客户端可以通过 client.Client.Register 注册自己的服务，服务端在同一个连接上调用它们，
+适用于客户端位于 NAT 之后、只能主动向外建立连接的场景。反向调用使用单独的 Kind，
+双方各自维护自己的 Seq 空间，与客户端发起的请求互不干扰：
+
+	server <- | Header{ServiceMethod: "Agent.Exec", Seq: 1, Kind: KindReverseCall} | Args |
+	client -> | Header{Seq: 1, Kind: KindReverseReply} | Reply |
+
+服务方法通过 PeerFromContext 取得发起请求的连接，保存下来之后即可随时调用：
+
+	func (r *Registry) Hello(ctx context.Context, id string, reply *bool) error {
+		peer, _ := server.PeerFromContext(ctx)
+		r.agents[id] = peer
+		return nil
+	}
+	err := r.agents[id].Call(ctx, "Agent.Exec", &cmd, &out)
+
+响应由读取循环解码，因此 Call 的 reply 需要在调用之前确定类型。连接断开时未完成的调用返回 ErrPeerGone。
+*/
+
+var (
+	ErrPeerGone             error = conn.NewError(conn.Unavailable, "FastRPC server: peer connection closed")
+	errReverseNotNegotiated error = conn.NewError(conn.Unimplemented, "FastRPC server: reverse call is not supported by the client")
+)
+
+// Peer 服务端一侧的连接，通过 Call 调用客户端注册的服务
+type Peer struct {
+	sc *serverConn
+}
+
+// reverseCall 一次进行中的反向调用
+type reverseCall struct {
+	reply interface{}
+	done  chan error // buffered, receives the result exactly once
+}
+
+// PeerFromContext 返回 ctx 所属的请求的连接，ctx 必须是传给服务方法的 ctx 或由它派生
+func PeerFromContext(ctx context.Context) (*Peer, bool) {
+	sc, ok := ctx.Value(serverConnKey{}).(*serverConn)
+	if !ok {
+		return nil, false
+	}
+	return &Peer{sc: sc}, true
+}
+
+// Call invokes the named function registered on the client, waits for it to complete, and returns its error status.
+// ctx 中的元数据和 deadline 与客户端的 Call 一样随请求发送
+func (p *Peer) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	sc := p.sc
+	if !sc.reversible {
+		return errReverseNotNegotiated
+	}
+
+	h := &conn.Header{ServiceMethod: serviceMethod, Kind: conn.KindReverseCall}
+	if sc.hasMetadata {
+		h.Metadata, _ = metadata.FromOutgoingContext(ctx)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if h.Timeout = time.Until(deadline); h.Timeout <= 0 {
+			return conn.Errorf(conn.DeadlineExceeded, "FastRPC server: reverse call failed: %s", context.DeadlineExceeded)
+		}
+	}
+
+	call := &reverseCall{reply: reply, done: make(chan error, 1)}
+	sc.mu.Lock()
+	if sc.closed {
+		sc.mu.Unlock()
+		return ErrPeerGone
+	}
+	sc.rseq++
+	h.Seq = sc.rseq
+	if sc.rpending == nil {
+		sc.rpending = make(map[uint64]*reverseCall)
+	}
+	sc.rpending[h.Seq] = call
+	sc.mu.Unlock()
+
+	sc.sending.Lock()
+	err := sc.cc.Write(h, args)
+	sc.sending.Unlock()
+	if err != nil {
+		sc.removeReverseCall(h.Seq)
+		return err
+	}
+
+	select {
+	case err := <-call.done:
+		return err
+	case <-ctx.Done():
+		sc.removeReverseCall(h.Seq)
+		return conn.Errorf(conn.CodeOf(ctx.Err()), "FastRPC server: reverse call failed: %s", ctx.Err())
+	}
+}
+
+func (sc *serverConn) removeReverseCall(seq uint64) *reverseCall {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	call := sc.rpending[seq]
+	delete(sc.rpending, seq)
+	return call
+}
+
+// receiveReverseReply 读取反向调用的响应，调用已经结束时丢弃响应
+func (sc *serverConn) receiveReverseReply(h *conn.Header) error {
+	call := sc.removeReverseCall(h.Seq)
+	switch {
+	case call == nil:
+		return sc.cc.ReadBody(nil)
+	case h.Err() != nil:
+		call.done <- h.Err()
+		return sc.cc.ReadBody(nil)
+	}
+	err := sc.cc.ReadBody(call.reply)
+	if err != nil {
+		call.done <- conn.Errorf(conn.Internal, "FastRPC server: reading reverse reply %s", err)
+		return err
+	}
+	call.done <- nil
+	return nil
+}
+
+// closeReverseCalls 连接断开，所有未完成的反向调用返回 ErrPeerGone
+func (sc *serverConn) closeReverseCalls() {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.closed = true
+	for seq, call := range sc.rpending {
+		call.done <- ErrPeerGone
+		delete(sc.rpending, seq)
+	}
+}
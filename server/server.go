@@ -1,10 +1,11 @@
 package server
 
 import (
-	"encoding/json"
+	"crypto/tls"
 	"errors"
 	"fastRPC/conn"
 	"fastRPC/service"
+	"fmt"
 	"io"
 	"log"
 	"net"
@@ -15,6 +16,13 @@ import (
 // Server represents an RPC Server.
 type Server struct {
 	serviceMap sync.Map
+
+	mu           sync.RWMutex // protect following
+	interceptors []UnaryServerInterceptor
+	listeners    map[net.Listener]struct{}
+	conns        map[*serverConn]struct{}
+	inShutdown   bool // Shutdown 或 Close 已被调用
+	maxRequests  int  // 每个连接上同时处理的最大请求数，见 flow.go
 }
 
 // NewServer returns a new Server.
@@ -45,21 +53,21 @@ func Register(this interface{}) error { return DefaultServer.Register(this) }
 func (server *Server) findService(serviceMethod string) (svc *service.Service, mType *service.MethodType, err error) {
 	dot := strings.LastIndex(serviceMethod, ".")
 	if dot < 0 {
-		err = errors.New("FastRPC server: service/method request ill-formed: " + serviceMethod)
+		err = conn.NewError(conn.InvalidArgument, "FastRPC server: service/method request ill-formed: "+serviceMethod)
 		return
 	}
 
 	serviceName, methodName := serviceMethod[:dot], serviceMethod[dot+1:]
 	svcInterface, ok := server.serviceMap.Load(serviceName)
 	if !ok {
-		err = errors.New("FastRPC server: can't find service: " + serviceName)
+		err = conn.NewError(conn.NotFound, "FastRPC server: can't find service: "+serviceName)
 		return
 	}
 
 	svc = svcInterface.(*service.Service)
 	mType = svc.GetMethod(methodName)
 	if mType == nil {
-		err = errors.New("FastRPC server: can't find method: " + methodName)
+		err = conn.NewError(conn.NotFound, "FastRPC server: can't find method: "+methodName)
 	}
 
 	return
@@ -70,11 +78,19 @@ func (server *Server) findService(serviceMethod string) (svc *service.Service, m
 // Accept accepts connections on the listener and serves requests
 // for each incoming connection.
 func (server *Server) Accept(lis net.Listener) {
+	if !server.trackListener(lis, true) {
+		_ = lis.Close()
+		return
+	}
+	defer server.trackListener(lis, false)
+
 	for {
 		// Accept 函数会阻塞程序，直到接收到来自端口的连接
 		cliConn, err := lis.Accept()
 		if err != nil {
-			log.Println("FastRPC server: accept error:", err)
+			if !server.shuttingDown() {
+				log.Println("FastRPC server: accept error:", err)
+			}
 			return
 		}
 
@@ -88,37 +104,58 @@ func Accept(lis net.Listener) {
 	DefaultServer.Accept(lis)
 }
 
-// ServeConn 首先使用 json.NewDecoder 反序列化得到 Option 实例
-// 检查 MagicNumber 和 CodeType 的值是否正确
-// 然后根据 CodeType 得到对应的消息编解码器，接下来的处理交给 serveRealConn
+// ServeConn 首先读取前导并反序列化得到 Option 实例，前导中已经校验了 MagicNumber
+// 然后检查 CodeType 的值是否正确并协商协议版本，根据 CodeType 得到对应的消息编解码器，接下来的处理交给 serveRealConn
 func (server *Server) ServeConn(cliConn io.ReadWriteCloser) {
 	defer func() {
 		_ = cliConn.Close()
 	}()
 
-	var opt conn.Option
-	// 服务端解码报文Option部分
-	if err := json.NewDecoder(cliConn).Decode(&opt); err != nil {
+	// TLS 连接先完成握手，见 tls.go
+	if tc, ok := cliConn.(*tls.Conn); ok && !handshake(tc) {
+		return
+	}
+
+	// 服务端解码报文Option部分，ReadOption 只会读走 Option 本身，不会吞掉后续的 Header
+	opt, err := conn.ReadOption(cliConn)
+	if err != nil {
 		log.Println("FastRPC server: option decode error: ", err)
+		return
 	}
 
-	if opt.MagicNumber != conn.MagicNumber {
-		log.Printf("FastRPC server: invalid magic number %x", opt.MagicNumber)
+	f, ok := conn.LookupCodec(opt.ConnType)
+	if !ok {
+		server.rejectConn(cliConn, opt, fmt.Errorf("FastRPC server: invalid conn type %s", opt.ConnType))
 		return
 	}
 
-	f := conn.NewConnFuncMap[opt.ConnType]
-	if f == nil {
-		log.Printf("FastRPC server: invalid conn type %s", opt.ConnType)
+	// 协商协议版本和能力，协商的结果随 Option 一起回复给客户端
+	if err := conn.Negotiate(opt, conn.SupportedCapabilities); err != nil {
+		server.rejectConn(cliConn, opt, err)
 		return
 	}
 
-	// TODO: 解决粘包问题
-	if err := json.NewEncoder(cliConn).Encode(opt); err != nil {
+	opt.ConnWindow = uint32(server.connWindow())
+
+	framer := conn.NewFramer(cliConn)
+	if err := framer.SetCompression(opt); err != nil {
+		server.rejectConn(cliConn, opt, err)
+		return
+	}
+
+	if err := conn.WriteOption(cliConn, opt); err != nil {
 		log.Printf("FastRPC server: option encode error: %s", err.Error())
 		return
 	}
 
 	// f(conn): 根据用户连接conn，动态生成gob或json类型的连接实例
-	server.serveRealConn(f(cliConn), &opt)
+	server.serveRealConn(f(framer), opt, cliConn)
+}
+
+// rejectConn 拒绝连接时，在回复的 Option 中写明原因，而不是直接关闭连接，便于客户端定位问题
+func (server *Server) rejectConn(cliConn io.Writer, opt *conn.Option, err error) {
+	log.Println("FastRPC server: reject connection:", err)
+	opt.Capabilities = 0
+	opt.Error = err.Error()
+	_ = conn.WriteOption(cliConn, opt)
 }
@@ -1,9 +1,11 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"fastRPC/conn"
+	"fastRPC/metadata"
 	"fastRPC/service"
-	"fmt"
 	"io"
 	"log"
 	"reflect"
@@ -27,40 +29,210 @@ serveRealConn 处理用户连接实例
 1. handleRequest 使用了协程并发执行请求；
 2. 处理请求是并发的，但是回复请求的报文必须是逐个发送的，并发容易导致多个回复报文交织在一起，客户端无法解析。在这里使用锁(sending)保证；
 3. 尽力而为，只有在 header 解析失败时，才终止循环。
+
+每个连接拥有一个 ctx，所有请求的 ctx 都由它派生。客户端断开或连接关闭时读取循环退出，ctx 被取消，
+正在执行的服务方法（接收 context.Context 的形式）可以据此提前结束。
+单向调用（KindOneWay）与普通请求的处理相同，只是不回复，出错时只记录日志。
+客户端也可以通过 KindCancel 帧取消某个 Seq 对应的请求，inflight 记录了每个处理中的请求的 cancel 函数。
+流式方法的消息、半关闭和额度归还同样由读取循环分发给 streams 中对应的流（见 stream.go），
+订阅和取消订阅推送主题的消息由读取循环记录在 serverConn 中（见 push.go），
+反向调用的响应同样由读取循环解码（见 reverse.go）。
+每个连接同时处理的请求数受窗口限制，请求回复后归还额度（见 flow.go）。
+连接被记录在 Server 中，Shutdown 时通过 serverConn 发送 GOAWAY 并等待处理中的请求完成（见 shutdown.go）。
 */
-func (server *Server) serveRealConn(cc conn.Conn, opt *conn.Option) {
+func (server *Server) serveRealConn(cc conn.Conn, opt *conn.Option, nc io.ReadWriteCloser) {
 	mutexSendResp := new(sync.Mutex) // make sure to send a complete response
 	wg := new(sync.WaitGroup)        // wait until all request are handled
+	inflight := new(sync.Map)        // seq -> context.CancelFunc of the request in progress
+	streams := new(sync.Map)         // seq -> *serverStream of the streaming request in progress
+	sc := &serverConn{
+		cc:          cc,
+		nc:          nc,
+		sending:     mutexSendResp,
+		window:      int(opt.ConnWindow),
+		flow:        opt.Capabilities.Has(conn.CapFlowControl),
+		hasMetadata: opt.Capabilities.Has(conn.CapMetadata),
+		pushable:    opt.Capabilities.Has(conn.CapPush),
+		reversible:  opt.Capabilities.Has(conn.CapReverse),
+	}
+	if sc.pushable {
+		sc.pushq = make(chan pushMsg, pushQueueSize)
+	}
+	ctx, cancel := context.WithCancel(withServerConn(context.Background(), sc))
+	if !server.trackConn(sc, true) {
+		cancel()
+		_ = cc.Close()
+		return
+	}
+	defer server.trackConn(sc, false)
+	if sc.pushable {
+		go sc.pushLoop(ctx.Done())
+	}
 
 	for {
-		req, err := server.readRequest(cc)
+		req, err := server.readRequest(ctx, cc)
 		if err != nil {
 			// Wait for the request indefinitely until an error occurs,
 			// such as the connection is closed or received invalid message, etc.
 			if req == nil {
 				break // it's not possible to recover, so close the connection
 			}
-			req.header.Error = err.Error()
-			server.sendResponse(cc, req.header, invalidRequest, mutexSendResp)
+			server.replyError(sc, req.header, err)
 			continue
 		}
+		if !isRequest(req.header) {
+			if err := server.handleControl(sc, req.header, inflight, streams); err != nil {
+				break
+			}
+			continue
+		}
+
+		if req.streamType() != service.Unary && !opt.Capabilities.Has(conn.CapStreaming) {
+			server.replyError(sc, req.header, conn.NewError(conn.Unimplemented, "FastRPC server: streaming is not negotiated: "+req.header.ServiceMethod))
+			continue
+		}
+		if req.streamType() != service.Unary && req.header.Kind == conn.KindOneWay {
+			server.replyError(sc, req.header, conn.NewError(conn.Unimplemented, "FastRPC server: streaming method can't be called one-way: "+req.header.ServiceMethod))
+			continue
+		}
+		if err := sc.acquire(); err != nil {
+			server.replyError(sc, req.header, err)
+			continue
+		}
+
+		// cancel 需要在读取下一个 header 之前注册，否则可能错过紧随其后的 KindCancel
+		timeout := handleTimeout(req.header, opt)
+		if timeout != 0 {
+			req.ctx, req.cancel = context.WithTimeout(req.ctx, timeout)
+		} else {
+			req.ctx, req.cancel = context.WithCancel(req.ctx)
+		}
+		// 单向调用的 Seq 都是 0，客户端也无法取消
+		cancelable := req.header.Kind == conn.KindCall || req.header.Kind == conn.KindBatch
+		if cancelable {
+			inflight.Store(req.header.Seq, req.cancel)
+		}
+		if req.streamType() != service.Unary {
+			req.stream = newServerStream(req, cc, mutexSendResp)
+			streams.Store(req.header.Seq, req.stream)
+		}
 		wg.Add(1)
-		go server.handleRequest(cc, req, mutexSendResp, wg, opt.HandleTimeout)
+		go func(req *request, seq uint64) {
+			// 超时之后服务方法可能仍在执行，等执行服务方法的协程都退出之后才归还额度
+			handlers := new(sync.WaitGroup)
+			if req.header.Kind == conn.KindBatch {
+				server.handleBatch(sc, req, wg, handlers, timeout)
+			} else {
+				server.handleRequest(cc, req, mutexSendResp, wg, handlers, timeout)
+			}
+			if cancelable {
+				inflight.Delete(seq)
+			}
+			streams.Delete(seq)
+			handlers.Wait()
+			sc.release()
+		}(req, req.header.Seq)
 	}
 
+	// the client has disconnected, cancel all the requests in progress
+	sc.closeReverseCalls()
+	cancel()
 	wg.Wait()
 	_ = cc.Close()
 }
 
+// replyError 直接以错误回复请求，单向调用只记录日志，两者都需要归还请求的额度
+func (server *Server) replyError(sc *serverConn, h *conn.Header, err error) {
+	if h.Kind == conn.KindOneWay {
+		log.Printf("FastRPC server: one-way call %s error: %v", h.ServiceMethod, err)
+	} else {
+		h.SetError(err)
+		server.sendResponse(sc.cc, h, invalidRequest, sc.sending)
+	}
+	sc.reject()
+}
+
+// handleControl 处理客户端发送的控制消息和流消息，只有读取消息体出错时才返回错误
+func (server *Server) handleControl(sc *serverConn, h *conn.Header, inflight, streams *sync.Map) error {
+	cc := sc.cc
+	var stream *serverStream
+	if s, ok := streams.Load(h.Seq); ok {
+		stream = s.(*serverStream)
+	}
+
+	switch {
+	case h.Kind == conn.KindCancel:
+		if cancel, ok := inflight.Load(h.Seq); ok {
+			cancel.(context.CancelFunc)()
+		}
+	case h.Kind == conn.KindSubscribe, h.Kind == conn.KindUnsubscribe:
+		sc.subscribe(h.ServiceMethod, h.Kind == conn.KindSubscribe)
+	case h.Kind == conn.KindReverseReply:
+		return sc.receiveReverseReply(h)
+	case stream == nil:
+		// the stream has ended, or an unknown kind from a newer client
+	case h.Kind == conn.KindStreamMsg:
+		return stream.receive(cc)
+	case h.Kind == conn.KindStreamEnd:
+		stream.closeInput()
+	case h.Kind == conn.KindWindowUpdate:
+		stream.addCredits(h.Window)
+	}
+	return cc.ReadBody(nil)
+}
+
+// handleTimeout 取客户端 deadline 剩余的时间和 Option.HandleTimeout 中较小的一个，0 表示不限制
+// 超时只会取消传给服务方法的 ctx：不接收 context.Context 的服务方法无法被打断，
+// 它的协程在服务方法返回后才退出，在此之前一直占用连接的额度
+func handleTimeout(h *conn.Header, opt *conn.Option) time.Duration {
+	timeout := opt.HandleTimeout
+	if h.Timeout > 0 && (timeout == 0 || h.Timeout < timeout) {
+		timeout = h.Timeout
+	}
+	return timeout
+}
+
 // request stores all information of a call
 type request struct {
-	header *conn.Header  // header of request
-	argv   reflect.Value // argv of request
-	replyv reflect.Value // replyv of request
+	header *conn.Header    // header of request
+	argv   reflect.Value   // argv of request
+	replyv reflect.Value   // replyv of request
+	ctx    context.Context // derived from the connection, carries the request metadata
+	cancel context.CancelFunc
 
 	// service
-	mType *service.MethodType
-	svc   *service.Service
+	mType  *service.MethodType
+	svc    *service.Service
+	stream *serverStream // not nil for streaming methods
+
+	batch []conn.BatchItem // calls of a batch request, mType and svc are nil, see batch.go
+}
+
+// streamType 请求的流类型，批量请求视为普通请求
+func (req *request) streamType() service.StreamType {
+	if req.mType == nil {
+		return service.Unary
+	}
+	return req.mType.StreamType()
+}
+
+// isRequest 是否是需要调用服务方法的请求，包括普通请求、单向调用和批量请求
+func isRequest(h *conn.Header) bool {
+	return h.Kind == conn.KindCall || h.Kind == conn.KindOneWay || h.Kind == conn.KindBatch
+}
+
+// metadataEnabled ctx 所属的连接是否协商了 CapMetadata
+func metadataEnabled(ctx context.Context) bool {
+	sc, ok := ctx.Value(serverConnKey{}).(*serverConn)
+	return !ok || sc.hasMetadata
+}
+
+// replyMetadata 服务方法设置的响应元数据，连接没有协商 CapMetadata 时返回 nil
+func replyMetadata(ctx context.Context) metadata.MD {
+	if !metadataEnabled(ctx) {
+		return nil
+	}
+	return metadata.ReplyFromIncomingContext(ctx)
 }
 
 func (server *Server) readRequestHeader(cc conn.Conn) (*conn.Header, error) {
@@ -74,32 +246,57 @@ func (server *Server) readRequestHeader(cc conn.Conn) (*conn.Header, error) {
 	return &h, nil
 }
 
-func (server *Server) readRequest(cc conn.Conn) (*request, error) {
+func (server *Server) readRequest(ctx context.Context, cc conn.Conn) (*request, error) {
 	h, err := server.readRequestHeader(cc)
 	if err != nil {
 		return nil, err
 	}
 
-	req := &request{header: h}
+	if !metadataEnabled(ctx) {
+		// 没有协商 CapMetadata 时忽略对端发来的元数据
+		h.Metadata = nil
+	}
+	// header 会被复用为响应的 header，请求的元数据转移到 ctx 中，避免被原样回传给客户端
+	req := &request{header: h, ctx: metadata.NewIncomingContext(ctx, h.Metadata)}
+	h.Metadata = nil
+	if !isRequest(h) {
+		// 控制消息和流消息的消息体由 handleControl 读取
+		return req, nil
+	}
+	if h.Kind == conn.KindBatch {
+		return req, server.readBatch(cc, req)
+	}
 	// search service
 	req.svc, req.mType, err = server.findService(h.ServiceMethod)
 	if err != nil {
+		// discard the body, otherwise it will be read as the next header
+		_ = cc.ReadBody(nil)
 		return req, err
 	}
 
-	req.argv, req.replyv = req.mType.NewArgv(), req.mType.NewReplyv()
-	// make sure that argvInterface is a pointer, ReadBody need a pointer as parameter
-	argvInterface := req.argv.Interface()
-	if req.argv.Type().Kind() != reflect.Ptr {
-		argvInterface = req.argv.Addr().Interface()
+	if req.mType.ReplyType != nil {
+		req.replyv = req.mType.NewReplyv()
 	}
-
-	if err = cc.ReadBody(argvInterface); err != nil {
+	if req.mType.StreamType().IsClientStream() {
+		// 客户端流式方法的请求没有消息体，argv 在创建 serverStream 时设置为 channel
+		return req, cc.ReadBody(nil)
+	}
+	req.argv = req.mType.NewArgv()
+	if err = cc.ReadBody(argvPointer(req.argv)); err != nil {
 		log.Println("FastRPC server: read body err:", err)
+		return req, conn.Errorf(conn.InvalidArgument, "FastRPC server: read body error: %v", err)
 	}
 	return req, nil
 }
 
+// argvPointer make sure that the argument is a pointer, ReadBody need a pointer as parameter
+func argvPointer(argv reflect.Value) interface{} {
+	if argv.Type().Kind() != reflect.Ptr {
+		return argv.Addr().Interface()
+	}
+	return argv.Interface()
+}
+
 func (server *Server) sendResponse(cc conn.Conn, h *conn.Header, body interface{}, mutexSendResp *sync.Mutex) {
 	mutexSendResp.Lock()
 	defer mutexSendResp.Unlock()
@@ -108,38 +305,77 @@ func (server *Server) sendResponse(cc conn.Conn, h *conn.Header, body interface{
 	}
 }
 
+// handleError 将服务方法返回的错误转换为回复给客户端的错误
+// 服务方法因为超时而返回错误时，同样回复超时错误
+func handleError(ctx context.Context, err error, timeout time.Duration) error {
+	var perr *service.PanicError
+	switch {
+	case err == nil:
+	case ctx.Err() == context.DeadlineExceeded:
+		err = conn.Errorf(conn.DeadlineExceeded, "FastRPC server: request handle timeout: expect within %s", timeout)
+	case errors.As(err, &perr):
+		err = conn.NewError(conn.Internal, perr.Error())
+	}
+	return err
+}
+
 /*
-这里需要确保 sendResponse 仅调用一次，因此将整个过程拆分为 called 和 sent 两个阶段，在这段代码中只会发生如下两种情况：
-1. called 管道接收到消息，代表处理没有超时，继续执行 sendResponse。
-2. time.After 先于 called 接收到消息，说明处理已经超时，called 和 sent 都将被阻塞。在 case<-time.After(timeout) 处调用 sendResponse
+handleRequest 需要确保每个请求至多回复一次，并且不会有协程因为超时而永久阻塞：
+1. 服务方法在子协程中执行，结果写入容量为 1 的管道 called，子协程写入后即可退出，不会因为无人接收而阻塞；
+2. 只有 handleRequest 本身会调用 sendResponse，并且只调用一次。called 先接收到结果，代表处理没有超时，回复处理结果；
+ctx.Done() 先接收到消息且原因是超时，回复超时错误，服务方法稍后返回的结果被丢弃；
+ctx.Done() 先接收到消息且原因是取消，说明客户端已经取消了请求或者连接已经断开，不需要回复。
+3. 超时或取消之后，接收 context.Context 的服务方法应当尽快返回，子协程随之退出；
+不接收 context.Context 的服务方法无法被打断，子协程会在服务方法返回后退出。
+子协程记录在 handlers 中，调用方等待它们退出之后才归还连接的额度，见 flow.go。
+
+传给服务方法的 ctx 在超时、客户端取消、客户端断开或连接关闭时被取消。
 */
-func (server *Server) handleRequest(cc conn.Conn, req *request, sending *sync.Mutex, wg *sync.WaitGroup, timeout time.Duration) {
+func (server *Server) handleRequest(cc conn.Conn, req *request, sending *sync.Mutex, wg, handlers *sync.WaitGroup, timeout time.Duration) {
 	defer wg.Done()
-	called := make(chan struct{})
-	sent := make(chan struct{})
+	defer req.cancel()
+
+	called := make(chan error, 1)
+	handlers.Add(1)
 	go func() {
-		err := req.svc.Call(req.mType, req.argv, req.replyv)
-		called <- struct{}{}
-		if err != nil {
-			req.header.Error = err.Error()
-			server.sendResponse(cc, req.header, invalidRequest, sending)
-			sent <- struct{}{}
+		defer handlers.Done()
+		called <- server.invoke(req)
+	}()
+
+	var err error
+	select {
+	case err = <-called:
+	case <-req.ctx.Done():
+		err = req.ctx.Err()
+		if err == context.Canceled {
+			if req.stream != nil {
+				req.stream.close(nil, nil)
+			}
 			return
 		}
-		server.sendResponse(cc, req.header, req.replyv.Interface(), sending)
-		sent <- struct{}{}
-	}()
+	}
 
-	if timeout == 0 {
-		<-called
-		<-sent
+	err = handleError(req.ctx, err, timeout)
+	if req.header.Kind == conn.KindOneWay {
+		if err != nil {
+			log.Printf("FastRPC server: one-way call %s error: %v", req.header.ServiceMethod, err)
+		}
 		return
 	}
-	select {
-	case <-time.After(timeout):
-		req.header.Error = fmt.Sprintf("FastRPC server: request handle timeout: expect within %s", timeout)
+	req.header.Metadata = replyMetadata(req.ctx)
+	if req.stream != nil {
+		var reply interface{}
+		if err == nil && req.replyv.IsValid() {
+			reply = req.replyv.Interface()
+		}
+		req.header.SetError(err)
+		req.stream.close(req.header, reply)
+		return
+	}
+	if err != nil {
+		req.header.SetError(err)
 		server.sendResponse(cc, req.header, invalidRequest, sending)
-	case <-called:
-		<-sent
+		return
 	}
+	server.sendResponse(cc, req.header, req.replyv.Interface(), sending)
 }
@@ -0,0 +1,205 @@
+package server
+
+import (
+	"context"
+	"fastRPC/conn"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+/*
+优雅关闭
+Shutdown 首先关闭所有的 listener，不再接受新的连接，然后向每个连接发送 KindGoAway 帧，
+客户端收到后不再在该连接上发起新的请求。已经在处理中的请求可以正常完成并回复，
+GOAWAY 之后才到达的请求直接回复 ErrServerShutdown。
+连接上没有处理中的请求时，服务端主动关闭该连接。所有连接都关闭后 Shutdown 返回，
+ctx 先结束时，剩余的连接被强制关闭。
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_ = server.Shutdown(ctx)
+*/
+
+var ErrServerShutdown error = conn.NewError(conn.Unavailable, "FastRPC server: server is shutting down")
+
+// serverConn 服务端的一个连接，记录处理中的请求数，用于在 Shutdown 时判断连接是否空闲
+type serverConn struct {
+	cc      conn.Conn
+	nc      io.ReadWriteCloser // the underlying connection, e.g. *tls.Conn, see tls.go
+	sending *sync.Mutex        // the same as mutexSendResp in serveRealConn
+
+	// for flow control, see flow.go
+	window int  // maximum number of requests in progress
+	flow   bool // CapFlowControl is negotiated
+
+	hasMetadata bool         // CapMetadata is negotiated
+	pushable    bool         // CapPush is negotiated, see push.go
+	pushq       chan pushMsg // messages to publish, nil if CapPush is not negotiated
+	reversible  bool         // CapReverse is negotiated, see reverse.go
+
+	mu       sync.Mutex          // protect following
+	active   int                 // number of requests in progress
+	draining bool                // no more requests are accepted
+	goneAway bool                // GOAWAY has been written
+	unacked  int                 // requests replied but not yet granted back to the client
+	topics   map[string]struct{} // topics subscribed by the client
+	closed   bool                // the connection is closed, no more reverse calls
+	rseq     uint64              // sequence number of the last reverse call
+	rpending map[uint64]*reverseCall
+}
+
+// acquire 开始处理一个请求，连接已经发送过 GOAWAY 或者处理中的请求数已经达到窗口大小时返回错误
+func (sc *serverConn) acquire() error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.draining {
+		return ErrServerShutdown
+	}
+	if sc.active >= sc.window {
+		return errTooManyRequests
+	}
+	sc.active++
+	return nil
+}
+
+// release 一个请求处理完成，GOAWAY 之后最后一个请求完成时关闭连接
+func (sc *serverConn) release() {
+	sc.mu.Lock()
+	sc.active--
+	n := sc.grant()
+	if sc.goneAway && sc.active == 0 {
+		sc.mu.Unlock()
+		_ = sc.cc.Close()
+		return
+	}
+	sc.mu.Unlock()
+	sc.sendWindow(n)
+}
+
+// goAway 通知客户端不再发送新的请求，连接空闲时直接关闭。
+// 对端不读数据时写操作可能一直阻塞，因此写 GOAWAY 时不持有 sc.mu，
+// Shutdown 在单独的协程中调用，阻塞的写操作在 ctx 结束、连接被关闭时返回。
+func (sc *serverConn) goAway() {
+	sc.mu.Lock()
+	if sc.draining {
+		sc.mu.Unlock()
+		return
+	}
+	sc.draining = true
+	sc.mu.Unlock()
+
+	sc.sending.Lock()
+	_ = sc.cc.Write(&conn.Header{Kind: conn.KindGoAway}, nil)
+	sc.sending.Unlock()
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.goneAway = true
+	if sc.active == 0 {
+		_ = sc.cc.Close()
+	}
+}
+
+// ============================================================
+
+func (server *Server) shuttingDown() bool {
+	server.mu.RLock()
+	defer server.mu.RUnlock()
+	return server.inShutdown
+}
+
+// trackListener 记录或移除 listener，服务端已经关闭时无法再添加
+func (server *Server) trackListener(lis net.Listener, add bool) bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if !add {
+		delete(server.listeners, lis)
+		return true
+	}
+	if server.inShutdown {
+		return false
+	}
+	if server.listeners == nil {
+		server.listeners = make(map[net.Listener]struct{})
+	}
+	server.listeners[lis] = struct{}{}
+	return true
+}
+
+// trackConn 记录或移除连接，服务端已经关闭时无法再添加
+func (server *Server) trackConn(sc *serverConn, add bool) bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if !add {
+		delete(server.conns, sc)
+		return true
+	}
+	if server.inShutdown {
+		return false
+	}
+	if server.conns == nil {
+		server.conns = make(map[*serverConn]struct{})
+	}
+	server.conns[sc] = struct{}{}
+	return true
+}
+
+// closeListeners 标记服务端已关闭并关闭所有的 listener，返回当前所有的连接
+func (server *Server) closeListeners() []*serverConn {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	server.inShutdown = true
+	for lis := range server.listeners {
+		_ = lis.Close()
+		delete(server.listeners, lis)
+	}
+	conns := make([]*serverConn, 0, len(server.conns))
+	for sc := range server.conns {
+		conns = append(conns, sc)
+	}
+	return conns
+}
+
+func (server *Server) numConns() int {
+	server.mu.RLock()
+	defer server.mu.RUnlock()
+	return len(server.conns)
+}
+
+// Shutdown gracefully shuts down the server: it stops accepting connections,
+// sends GOAWAY to every connection and waits for the requests in progress.
+// If ctx is done before that, the remaining connections are closed and ctx.Err() is returned.
+func (server *Server) Shutdown(ctx context.Context) error {
+	for _, sc := range server.closeListeners() {
+		go sc.goAway()
+	}
+
+	// 连接在 serveRealConn 退出时被移除，轮询等待所有连接关闭
+	interval := time.Millisecond
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	for server.numConns() > 0 {
+		select {
+		case <-ctx.Done():
+			_ = server.Close()
+			return ctx.Err()
+		case <-timer.C:
+			if interval < time.Millisecond*100 {
+				interval *= 2
+			}
+			timer.Reset(interval)
+		}
+	}
+	return nil
+}
+
+// Close immediately closes all listeners and connections.
+// 传给服务方法的 ctx 随之被取消，未完成的请求不再回复。
+func (server *Server) Close() error {
+	for _, sc := range server.closeListeners() {
+		_ = sc.cc.Close()
+	}
+	return nil
+}
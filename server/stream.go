@@ -0,0 +1,272 @@
+package server
+
+import (
+	"context"
+	"fastRPC/conn"
+	"fastRPC/service"
+	"log"
+	"reflect"
+	"sync"
+)
+
+/*
+流式方法
+服务端流式方法的请求与普通方法相同，服务方法通过 ServerStream.Send 发送的每条消息都是一个 KindStreamMsg 帧，
+方法返回后发送 KindStreamEnd 帧结束流，Header 中的 Error/Code 即方法返回的错误：
+
+	client -> | Header{Seq: 1} | Args |
+	server <- | Header{Seq: 1, Kind: KindStreamMsg} | Msg | × N
+	server <- | Header{Seq: 1, Kind: KindStreamEnd} | (empty) |
+
+客户端流式方法（以及双向流）的请求没有消息体，客户端随后在同一个 Seq 下发送 KindStreamMsg，
+并以 KindStreamEnd 表示不再发送（半关闭），此时传给服务方法的 channel 被关闭。
+客户端流式方法返回后，reply 作为一条 KindStreamMsg 发送，随后是 KindStreamEnd。
+
+流量控制：每个方向初始都有 conn.StreamWindow 条消息的额度，发送一条消息消耗一个额度，
+额度用完后发送方阻塞，直到接收方消费了消息并通过 KindWindowUpdate 归还额度。
+因此无论处理得多慢，每个流缓存的消息都不会超过 StreamWindow 条。
+
+流式方法只有在协商了 CapStreaming 时才能被调用。超时、取消的处理与普通方法相同，
+流结束之后 Send 直接返回错误。
+*/
+
+// serverStream 实现了 service.ServerStream
+type serverStream struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	cc      conn.Conn
+	seq     uint64
+	sending *sync.Mutex // the same as mutexSendResp in serveRealConn
+
+	// 写消息时持有 mutexSend 而不是 mu，读循环中的 receive/addCredits 不会被阻塞的写操作卡住。
+	// close 在持有 mutexSend 时设置 closed，Send 拿到 mutexSend 后再检查 closed，保证结束帧之后不会再有消息
+	mutexSend sync.Mutex
+
+	mu      sync.Mutex // protect following
+	closed  bool
+	credits int           // 还可以发送的消息数
+	wake    chan struct{} // credits 增加时通知 Send
+
+	// 客户端发送的消息，只有客户端流式方法才有
+	in       reflect.Value   // chan T1，传给服务方法的是它的只读形式
+	inbox    []reflect.Value // 已经收到，还没有被服务方法读取的消息
+	inClosed bool            // 客户端已经半关闭
+	inWake   chan struct{}   // inbox 发生变化时通知 pump
+	consumed int             // 服务方法已经读取，还没有归还额度的消息数
+}
+
+var _ service.ServerStream = (*serverStream)(nil)
+
+func newServerStream(req *request, cc conn.Conn, sending *sync.Mutex) *serverStream {
+	s := &serverStream{
+		ctx:     req.ctx,
+		cancel:  req.cancel,
+		cc:      cc,
+		seq:     req.header.Seq,
+		sending: sending,
+		credits: conn.StreamWindow,
+		wake:    make(chan struct{}, 1),
+	}
+	if req.mType.StreamType().IsClientStream() {
+		s.in = reflect.MakeChan(reflect.ChanOf(reflect.BothDir, req.mType.ArgType.Elem()), 0)
+		s.inWake = make(chan struct{}, 1)
+		req.argv = s.in.Convert(req.mType.ArgType)
+		go s.pump()
+	}
+	return s
+}
+
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+// Send 发送一条消息，额度用完时阻塞，直到客户端归还额度或者 ctx 结束
+func (s *serverStream) Send(msg interface{}) error {
+	for {
+		s.mu.Lock()
+		if err := s.ctx.Err(); err != nil {
+			s.mu.Unlock()
+			return err
+		}
+		if s.closed {
+			s.mu.Unlock()
+			return conn.NewError(conn.FailedPrecondition, "FastRPC server: stream already closed")
+		}
+		if s.credits > 0 {
+			s.credits--
+			if s.credits > 0 {
+				// wake 只能唤醒一个等待者，还有剩余额度时继续唤醒下一个
+				notify(s.wake)
+			}
+			s.mu.Unlock()
+			return s.writeMsg(&conn.Header{Seq: s.seq, Kind: conn.KindStreamMsg}, msg)
+		}
+		s.mu.Unlock()
+
+		select {
+		case <-s.wake:
+		case <-s.ctx.Done():
+		}
+	}
+}
+
+// writeMsg 在流结束之前发送一帧，流已经结束时返回错误
+func (s *serverStream) writeMsg(h *conn.Header, body interface{}) error {
+	s.mutexSend.Lock()
+	defer s.mutexSend.Unlock()
+	s.mu.Lock()
+	closed := s.closed
+	s.mu.Unlock()
+	if closed {
+		return conn.NewError(conn.FailedPrecondition, "FastRPC server: stream already closed")
+	}
+	return s.write(h, body)
+}
+
+func (s *serverStream) write(h *conn.Header, body interface{}) error {
+	s.sending.Lock()
+	defer s.sending.Unlock()
+	return s.cc.Write(h, body)
+}
+
+// addCredits 客户端归还了 n 个额度
+func (s *serverStream) addCredits(n uint32) {
+	s.mu.Lock()
+	s.credits += int(n)
+	s.mu.Unlock()
+	notify(s.wake)
+}
+
+// close 结束流，之后的 Send 都会返回错误
+// h 不为 nil 时发送结束帧，reply 不为 nil 时（客户端流式方法）在结束帧之前发送 reply
+func (s *serverStream) close(h *conn.Header, reply interface{}) {
+	s.mutexSend.Lock()
+	defer s.mutexSend.Unlock()
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	s.mu.Unlock()
+	if h == nil {
+		return
+	}
+
+	if reply != nil {
+		if err := s.write(&conn.Header{Seq: s.seq, Kind: conn.KindStreamMsg}, reply); err != nil {
+			return
+		}
+	}
+	h.Kind = conn.KindStreamEnd
+	_ = s.write(h, nil)
+}
+
+// abort 以错误结束流并取消服务方法，用于客户端违反了协议的情况
+func (s *serverStream) abort(err error) {
+	log.Println("FastRPC server: abort stream:", err)
+	h := &conn.Header{Seq: s.seq}
+	h.SetError(err)
+	s.close(h, nil)
+	s.cancel()
+}
+
+// ============================================================
+
+// receive 读取客户端发送的一条消息，放入 inbox
+func (s *serverStream) receive(cc conn.Conn) error {
+	if !s.in.IsValid() {
+		_ = cc.ReadBody(nil)
+		s.abort(conn.NewError(conn.FailedPrecondition, "FastRPC server: the method does not accept a client stream"))
+		return nil
+	}
+	v := reflect.New(s.in.Type().Elem())
+	if err := cc.ReadBody(v.Interface()); err != nil {
+		s.abort(conn.Errorf(conn.InvalidArgument, "FastRPC server: read stream message error: %v", err))
+		return nil
+	}
+
+	s.mu.Lock()
+	if len(s.inbox) >= conn.StreamWindow {
+		s.mu.Unlock()
+		s.abort(conn.NewError(conn.ResourceExhausted, "FastRPC server: stream window exceeded"))
+		return nil
+	}
+	s.inbox = append(s.inbox, v.Elem())
+	s.mu.Unlock()
+	notify(s.inWake)
+	return nil
+}
+
+// closeInput 客户端半关闭，inbox 中的消息被读完后关闭 channel
+func (s *serverStream) closeInput() {
+	if !s.in.IsValid() {
+		return
+	}
+	s.mu.Lock()
+	s.inClosed = true
+	s.mu.Unlock()
+	notify(s.inWake)
+}
+
+// pump 将 inbox 中的消息逐条交给服务方法，服务方法每读取一半窗口的消息，归还相应的额度
+func (s *serverStream) pump() {
+	defer s.in.Close()
+	done := reflect.ValueOf(s.ctx.Done())
+	for {
+		s.mu.Lock()
+		if len(s.inbox) == 0 {
+			inClosed := s.inClosed
+			s.mu.Unlock()
+			if inClosed {
+				return
+			}
+			select {
+			case <-s.inWake:
+			case <-s.ctx.Done():
+				return
+			}
+			continue
+		}
+		v := s.inbox[0]
+		s.inbox[0] = reflect.Value{}
+		s.inbox = s.inbox[1:]
+		s.mu.Unlock()
+
+		chosen, _, _ := reflect.Select([]reflect.SelectCase{
+			{Dir: reflect.SelectSend, Chan: s.in, Send: v},
+			{Dir: reflect.SelectRecv, Chan: done},
+		})
+		if chosen == 1 {
+			return
+		}
+
+		s.mu.Lock()
+		s.consumed++
+		n := s.consumed
+		if n >= conn.StreamWindow/2 {
+			s.consumed = 0
+		}
+		s.mu.Unlock()
+		if n >= conn.StreamWindow/2 {
+			_ = s.writeMsg(&conn.Header{Seq: s.seq, Kind: conn.KindWindowUpdate, Window: uint32(n)}, nil)
+		}
+	}
+}
+
+// contextStream 拦截器可能替换了 ctx，服务方法通过 Context 取得的应当是同一个 ctx
+type contextStream struct {
+	service.ServerStream
+	ctx context.Context
+}
+
+func (s contextStream) Context() context.Context {
+	return s.ctx
+}
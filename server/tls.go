@@ -0,0 +1,83 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"log"
+	"net"
+	"time"
+)
+
+/*
+TLS
+AcceptTLS 在 Accept 的基础上为每个连接先完成 TLS 握手，再进行 Option 的协议交换。
+双向认证（mTLS）时在 config 中设置 ClientAuth 为 tls.RequireAndVerifyClientCert 并提供 ClientCAs，
+经过验证的客户端证书可以在服务方法和拦截器中通过 Peer 读取：
+
+	func (s *Svc) Method(ctx context.Context, args Args, reply *Reply) error {
+		peer, _ := server.PeerFromContext(ctx) // 拦截器中也可以使用 info.Peer
+		if peer.Identity() != "agent-1" {
+			return conn.NewError(conn.PermissionDenied, "permission denied")
+		}
+		...
+	}
+*/
+
+// tlsHandshakeTimeout 服务端等待 TLS 握手完成的时间，防止客户端建立连接之后迟迟不握手
+const tlsHandshakeTimeout = time.Second * 10
+
+// AcceptTLS accepts connections on the listener and serves requests over TLS.
+func (server *Server) AcceptTLS(lis net.Listener, config *tls.Config) {
+	server.Accept(tls.NewListener(lis, config))
+}
+
+// AcceptTLS accepts connections on the listener and serves requests over TLS for the DefaultServer.
+func AcceptTLS(lis net.Listener, config *tls.Config) {
+	DefaultServer.AcceptTLS(lis, config)
+}
+
+// handshake 完成 TLS 握手，握手失败时连接被关闭
+func handshake(tc *tls.Conn) bool {
+	_ = tc.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+	if err := tc.Handshake(); err != nil {
+		log.Println("FastRPC server: tls handshake error:", err)
+		return false
+	}
+	_ = tc.SetDeadline(time.Time{})
+	return true
+}
+
+// RemoteAddr 返回客户端的地址，连接不是 net.Conn 时返回 nil
+func (p *Peer) RemoteAddr() net.Addr {
+	if nc, ok := p.sc.nc.(net.Conn); ok {
+		return nc.RemoteAddr()
+	}
+	return nil
+}
+
+// TLSState 返回连接的 TLS 状态，不是 TLS 连接时返回 nil
+func (p *Peer) TLSState() *tls.ConnectionState {
+	tc, ok := p.sc.nc.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	state := tc.ConnectionState()
+	return &state
+}
+
+// Certificate 返回经过验证的客户端证书，没有进行双向认证时返回 nil
+func (p *Peer) Certificate() *x509.Certificate {
+	state := p.TLSState()
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return state.VerifiedChains[0][0]
+}
+
+// Identity 返回经过验证的客户端证书的 CommonName，没有进行双向认证时返回空字符串
+func (p *Peer) Identity() string {
+	if cert := p.Certificate(); cert != nil {
+		return cert.Subject.CommonName
+	}
+	return ""
+}
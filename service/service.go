@@ -1,29 +1,79 @@
 package service
 
 import (
+	"context"
+	"fmt"
 	"go/ast"
 	"log"
 	"reflect"
+	"runtime/debug"
 	"sync/atomic"
 )
 
+// PanicError 服务方法发生 panic 时返回的错误
+type PanicError struct {
+	ServiceMethod string      // format "<service>.<method>"
+	Value         interface{} // 传给 panic 的值
+	Stack         []byte      // 发生 panic 时的调用栈，只记录在服务端的日志中
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("FastRPC server: panic in %s: %v", e.ServiceMethod, e.Value)
+}
+
+// NewPanicError 构造函数，记录当前的调用栈，需要在 recover 所在的 defer 中调用
+func NewPanicError(serviceMethod string, v interface{}) *PanicError {
+	return &PanicError{ServiceMethod: serviceMethod, Value: v, Stack: debug.Stack()}
+}
+
 // ================================
 // 通过反射实现结构体与服务的映射关系
 // ================================
 
 // MethodType 实例包含了一个方法的完整信息
 // func (t *T) MethodName(argType T1, replyType *T2) error
+// func (t *T) MethodName(ctx context.Context, argType T1, replyType *T2) error
+// func (t *T) MethodName(ctx context.Context, argType T1, stream service.ServerStream) error
+// func (t *T) MethodName(ctx context.Context, in <-chan T1, replyType *T2) error
+// func (t *T) MethodName(ctx context.Context, in <-chan T1, stream service.ServerStream) error
 type MethodType struct {
 	method    reflect.Method // 方法本身
-	ArgType   reflect.Type   // 客户端参数（值或指针类型）
-	ReplyType reflect.Type   // 服务端返回的数据（指针类型）
+	ArgType   reflect.Type   // 客户端参数（值或指针类型），客户端流式方法为 <-chan T1
+	ReplyType reflect.Type   // 服务端返回的数据（指针类型），流式方法为 nil
 	numCalls  uint64         // 统计方法调用次数时会用到
+	numPanics uint64         // 统计方法发生 panic 的次数
+	withCtx   bool           // 第一个参数是否为 context.Context
+	stream    StreamType     // 流的类型，见 stream.go
 }
 
 func (m *MethodType) NumCalls() uint64 {
 	return atomic.LoadUint64(&m.numCalls)
 }
 
+func (m *MethodType) NumPanics() uint64 {
+	return atomic.LoadUint64(&m.numPanics)
+}
+
+// IncPanics 记录一次发生在方法之外（例如拦截器中）的 panic，方法本身的 panic 由 Service 自动计数
+func (m *MethodType) IncPanics() {
+	atomic.AddUint64(&m.numPanics, 1)
+}
+
+// Name 返回方法名
+func (m *MethodType) Name() string {
+	return m.method.Name
+}
+
+// StreamType 返回方法的流类型，普通方法为 Unary
+func (m *MethodType) StreamType() StreamType {
+	return m.stream
+}
+
+// HasContext 返回方法的第一个参数是否为 context.Context
+func (m *MethodType) HasContext() bool {
+	return m.withCtx
+}
+
 // NewArgv 和 NewReplyv，用于创建对应类型的实例
 func (m *MethodType) NewArgv() reflect.Value {
 	var argv reflect.Value
@@ -84,10 +134,19 @@ func NewService(this interface{}) *Service {
 	return s
 }
 
+var (
+	typeOfError   = reflect.TypeOf((*error)(nil)).Elem()
+	typeOfContext = reflect.TypeOf((*context.Context)(nil)).Elem()
+)
+
 // registerMethods 过滤出了符合条件的方法
 // func (t *T) MethodName(argType T1, replyType *T2) error
+// func (t *T) MethodName(ctx context.Context, argType T1, replyType *T2) error
 // 1. 两个导出或内置类型的入参（反射时为 3 个，第 0 个是自身，类似于 python 的 self，C++ 中的 this）
 // 2. 返回值有且只有 1 个，类型为 error
+// 3. 可以在最前面额外接收一个 context.Context，用于感知超时、取消和读取元数据，两种形式的方法可以在同一个服务中共存
+// 4. 最后一个参数为 ServerStream 时是服务端流式方法，通过 stream 发送任意多条消息
+// 5. 参数为只读的 channel 时是客户端流式方法，客户端发送的消息依次从 channel 中读出，两者可以同时使用（双向流）
 func (s *Service) registerMethods() {
 	s.method = make(map[string]*MethodType)
 
@@ -96,17 +155,30 @@ func (s *Service) registerMethods() {
 		method := s.typ.Method(i)
 		mType := method.Type
 
-		// 因为NumIn()包括this、argType、replyType，NumOut()为error
-		if mType.NumIn() != 3 || mType.NumOut() != 1 {
+		// 因为NumIn()包括this、(ctx)、argType、replyType，NumOut()为error
+		withCtx := mType.NumIn() == 4 && mType.In(1) == typeOfContext
+		if (mType.NumIn() != 3 && !withCtx) || mType.NumOut() != 1 {
 			continue
 		}
 
 		// reflect.Type.Out 返回函数类型的输出参数类型列表，列表里应该只有一个error类型
-		if mType.Out(0) != reflect.TypeOf((*error)(nil)).Elem() {
+		if mType.Out(0) != typeOfError {
 			continue
 		}
-		argType, replyType := mType.In(1), mType.In(2)
-		if !isExportedOrBuiltinType(argType) || !isExportedOrBuiltinType(replyType) {
+		argType, replyType := mType.In(mType.NumIn()-2), mType.In(mType.NumIn()-1)
+		stream, msgType := Unary, argType
+		if argType.Kind() == reflect.Chan && argType.ChanDir() == reflect.RecvDir {
+			stream, msgType = ClientStreaming, argType.Elem()
+		}
+		if replyType == typeOfServerStream {
+			replyType = nil
+			if stream == ClientStreaming {
+				stream = BidiStreaming
+			} else {
+				stream = ServerStreaming
+			}
+		}
+		if !isExportedOrBuiltinType(msgType) || (replyType != nil && !isExportedOrBuiltinType(replyType)) {
 			continue
 		}
 
@@ -114,6 +186,8 @@ func (s *Service) registerMethods() {
 			method:    method,
 			ArgType:   argType,
 			ReplyType: replyType,
+			withCtx:   withCtx,
+			stream:    stream,
 		}
 		log.Printf("FastRPC server: register %s.%s\n", s.name, method.Name)
 	}
@@ -125,10 +199,38 @@ func isExportedOrBuiltinType(t reflect.Type) bool {
 
 // Call 能够通过反射值调用方法
 func (s *Service) Call(m *MethodType, argv, replyv reflect.Value) error {
+	return s.CallContext(context.Background(), m, argv, replyv)
+}
+
+// CallContext 与 Call 相同，方法接收 context.Context 时将 ctx 作为第一个参数传入
+// 方法发生 panic 时不会导致整个进程退出，panic 被恢复并以 *PanicError 的形式返回
+func (s *Service) CallContext(ctx context.Context, m *MethodType, argv, replyv reflect.Value) error {
+	return s.call(ctx, m, argv, replyv)
+}
+
+// CallStream 调用服务端流式方法，方法通过 stream 发送消息
+func (s *Service) CallStream(ctx context.Context, m *MethodType, argv reflect.Value, stream ServerStream) error {
+	return s.call(ctx, m, argv, reflect.ValueOf(&stream).Elem())
+}
+
+// call 通过反射调用方法，last 是方法的最后一个参数，即 replyv 或者 stream
+func (s *Service) call(ctx context.Context, m *MethodType, argv, last reflect.Value) (err error) {
 	atomic.AddUint64(&m.numCalls, 1)
+	defer func() {
+		if r := recover(); r != nil {
+			m.IncPanics()
+			perr := NewPanicError(s.name+"."+m.method.Name, r)
+			log.Printf("FastRPC server: %v\n%s", perr, perr.Stack)
+			err = perr
+		}
+	}()
 
 	f := m.method.Func
-	returnValues := f.Call([]reflect.Value{s.this, argv, replyv})
+	in := []reflect.Value{s.this, argv, last}
+	if m.withCtx {
+		in = []reflect.Value{s.this, reflect.ValueOf(ctx), argv, last}
+	}
+	returnValues := f.Call(in)
 
 	if errInter := returnValues[0].Interface(); errInter != nil {
 		return errInter.(error)
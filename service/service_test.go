@@ -1,9 +1,11 @@
 package service
 
 import (
+	"context"
 	"fmt"
 	"reflect"
 	"testing"
+	"time"
 )
 
 // 定义结构体 Foo，实现 2 个方法，导出方法 Sum 和 非导出方法 sums
@@ -24,6 +26,19 @@ func (f Foo) sum(args Args, reply *int) error {
 	return nil
 }
 
+// Bar 同时包含两种形式的方法
+type Bar int
+
+func (b Bar) Sum(args Args, reply *int) error {
+	*reply = args.Num1 + args.Num2
+	return nil
+}
+
+func (b Bar) Deadline(ctx context.Context, args Args, reply *bool) error {
+	_, *reply = ctx.Deadline()
+	return nil
+}
+
 func _assert(condition bool, msg string, v ...interface{}) {
 	if !condition {
 		panic(fmt.Sprintf("assertion failed: "+msg, v...))
@@ -50,3 +65,115 @@ func TestMethodType_Call(t *testing.T) {
 	err := s.Call(mType, argv, replyv)
 	_assert(err == nil && *replyv.Interface().(*int) == 4 && mType.NumCalls() == 1, "failed to call Foo.Sum")
 }
+
+func TestService_CallContext(t *testing.T) {
+	var bar Bar
+	s := NewService(&bar)
+	_assert(len(s.method) == 2, "wrong service Method, expect 2, but got %d", len(s.method))
+	mType := s.method["Deadline"]
+	_assert(mType != nil && mType.HasContext() && !s.method["Sum"].HasContext(), "wrong Method, Deadline should accept a context")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	argv, replyv := mType.NewArgv(), mType.NewReplyv()
+	err := s.CallContext(ctx, mType, argv, replyv)
+	_assert(err == nil && *replyv.Interface().(*bool), "failed to call Bar.Deadline with context")
+	err = s.Call(mType, argv, replyv)
+	_assert(err == nil && !*replyv.Interface().(*bool), "failed to call Bar.Deadline without context")
+}
+
+// Baz.Div 除数为 0 时发生 panic
+type Baz int
+
+func (b Baz) Div(args Args, reply *int) error {
+	*reply = args.Num1 / args.Num2
+	return nil
+}
+
+func TestService_CallPanic(t *testing.T) {
+	var baz Baz
+	s := NewService(&baz)
+	mType := s.method["Div"]
+
+	argv, replyv := mType.NewArgv(), mType.NewReplyv()
+	argv.Set(reflect.ValueOf(Args{Num1: 1, Num2: 0}))
+	err := s.Call(mType, argv, replyv)
+	perr, ok := err.(*PanicError)
+	_assert(ok && perr.ServiceMethod == "Baz.Div" && len(perr.Stack) > 0, "expect a panic error, but got %v", err)
+	_assert(mType.NumCalls() == 1 && mType.NumPanics() == 1, "expect 1 panic, but got %d", mType.NumPanics())
+
+	argv.Set(reflect.ValueOf(Args{Num1: 4, Num2: 2}))
+	err = s.Call(mType, argv, replyv)
+	_assert(err == nil && *replyv.Interface().(*int) == 2 && mType.NumPanics() == 1, "failed to call Baz.Div after panic")
+
+	// 拦截器中的 panic 由服务端恢复并计入同一个方法
+	mType.IncPanics()
+	_assert(mType.NumCalls() == 2 && mType.NumPanics() == 2, "expect 2 panics, but got %d", mType.NumPanics())
+}
+
+// Qux.Count 服务端流式方法
+type Qux int
+
+type sliceStream struct {
+	msgs []interface{}
+}
+
+func (s *sliceStream) Context() context.Context { return context.Background() }
+func (s *sliceStream) Send(msg interface{}) error {
+	s.msgs = append(s.msgs, msg)
+	return nil
+}
+
+func (q Qux) Count(n int, stream ServerStream) error {
+	for i := 0; i < n; i++ {
+		_ = stream.Send(i)
+	}
+	return nil
+}
+
+func TestService_CallStream(t *testing.T) {
+	var qux Qux
+	s := NewService(&qux)
+	mType := s.method["Count"]
+	_assert(mType != nil && mType.StreamType() == ServerStreaming && mType.ReplyType == nil, "Count should be a server-streaming method")
+
+	argv := mType.NewArgv()
+	argv.Set(reflect.ValueOf(3))
+	stream := new(sliceStream)
+	err := s.CallStream(context.Background(), mType, argv, stream)
+	_assert(err == nil && len(stream.msgs) == 3, "failed to call Qux.Count: %v", err)
+}
+
+// Qux.Total 客户端流式方法，Qux.Echo 双向流
+func (q Qux) Total(ctx context.Context, in <-chan int, reply *int) error {
+	for n := range in {
+		*reply += n
+	}
+	return ctx.Err()
+}
+
+func (q Qux) Echo(ctx context.Context, in <-chan int, stream ServerStream) error {
+	for n := range in {
+		_ = stream.Send(n)
+	}
+	return ctx.Err()
+}
+
+func TestService_ClientStream(t *testing.T) {
+	var qux Qux
+	s := NewService(&qux)
+	total, echo := s.method["Total"], s.method["Echo"]
+	_assert(total != nil && total.StreamType() == ClientStreaming && total.ArgType.Kind() == reflect.Chan,
+		"Total should be a client-streaming method")
+	_assert(echo != nil && echo.StreamType() == BidiStreaming && echo.ReplyType == nil,
+		"Echo should be a bidi-streaming method")
+
+	in := make(chan int, 3)
+	in <- 1
+	in <- 2
+	in <- 3
+	close(in)
+	replyv := total.NewReplyv()
+	err := s.CallContext(context.Background(), total, reflect.ValueOf(in).Convert(total.ArgType), replyv)
+	_assert(err == nil && *replyv.Interface().(*int) == 6, "failed to call Qux.Total: %v", err)
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"context"
+	"reflect"
+)
+
+/*
+流式方法
+普通方法每个请求只有一个响应，服务端流式方法可以在同一个 Seq 下向客户端发送任意多条消息，
+方法返回时流随之结束，返回的错误作为流的最终状态发送给客户端：
+
+	func (t *T) Export(ctx context.Context, args Args, stream service.ServerStream) error {
+		for _, row := range rows {
+			if err := stream.Send(&row); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+客户端流式方法通过只读的 channel 接收客户端发送的消息，客户端结束发送（CloseSend）后 channel 被关闭，
+ctx 结束时 channel 同样会被关闭，因此读完 channel 之后需要检查 ctx.Err()：
+
+	func (t *T) Ingest(ctx context.Context, in <-chan *Row, reply *int) error {
+		for row := range in {
+			*reply += row.Size
+		}
+		return ctx.Err()
+	}
+
+参数为 channel、最后一个参数为 ServerStream 时即双向流，接收和发送可以同时进行。
+*/
+
+// StreamType 方法的流类型
+type StreamType int
+
+const (
+	Unary           StreamType = iota // 一个请求，一个响应
+	ServerStreaming                   // 一个请求，任意多个响应
+	ClientStreaming                   // 任意多个请求，一个响应
+	BidiStreaming                     // 任意多个请求，任意多个响应
+)
+
+func (t StreamType) String() string {
+	switch t {
+	case Unary:
+		return "unary"
+	case ServerStreaming:
+		return "server-streaming"
+	case ClientStreaming:
+		return "client-streaming"
+	case BidiStreaming:
+		return "bidi-streaming"
+	}
+	return "unknown"
+}
+
+// IsClientStream 客户端是否发送消息流
+func (t StreamType) IsClientStream() bool {
+	return t == ClientStreaming || t == BidiStreaming
+}
+
+// IsServerStream 服务端是否发送消息流
+func (t StreamType) IsServerStream() bool {
+	return t == ServerStreaming || t == BidiStreaming
+}
+
+// ServerStream 服务端流式方法用来向客户端发送消息
+type ServerStream interface {
+	// Context 返回请求的 ctx，与传给方法的 ctx 相同
+	Context() context.Context
+	// Send 发送一条消息，流已经结束（例如客户端取消或者超时）时返回错误，方法应当停止发送并返回
+	Send(msg interface{}) error
+}
+
+var typeOfServerStream = reflect.TypeOf((*ServerStream)(nil)).Elem()
@@ -0,0 +1,147 @@
+package test
+
+import (
+	"context"
+	"fastRPC/client"
+	"fastRPC/conn"
+	"fastRPC/server"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClient_Batch(t *testing.T) {
+	var calc Calc
+	var u Users
+	var sleeper Sleeper
+	srv := server.NewServer()
+	_ = srv.Register(&calc)
+	_ = srv.Register(&u)
+	_ = srv.Register(&sleeper)
+	var mu sync.Mutex
+	intercepted := make(map[string]int)
+	srv.Use(func(ctx context.Context, info *server.UnaryServerInfo, args interface{}, handler server.UnaryHandler) error {
+		mu.Lock()
+		intercepted[info.ServiceMethod]++
+		mu.Unlock()
+		return handler(ctx, args)
+	})
+	addr := serve(t, srv)
+
+	for _, typ := range []conn.Type{conn.GobType, conn.JsonType} {
+		t.Run(string(typ), func(t *testing.T) {
+			c, err := client.Dial("tcp", addr, &conn.Option{ConnType: typ})
+			_assert(err == nil, "dial error: %v", err)
+			defer func() { _ = c.Close() }()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			mu.Lock()
+			intercepted = make(map[string]int)
+			mu.Unlock()
+			calls := []*client.BatchCall{
+				{ServiceMethod: "Calc.Sum", Args: &CalcArgs{Num1: 1, Num2: 2}, Reply: new(int)},
+				{ServiceMethod: "Users.Get", Args: 0, Reply: new(string)},
+				{ServiceMethod: "Calc.Mul", Args: &CalcArgs{Num1: 1, Num2: 2}, Reply: new(int)},
+				{ServiceMethod: "Calc.Sum", Args: CalcArgs{Num1: 3, Num2: 4}, Reply: new(int)},
+				{ServiceMethod: "Users.Get", Args: 1, Reply: new(string)},
+			}
+			err = c.Batch(ctx, calls)
+			_assert(err == nil, "failed to call batch: %v", err)
+
+			_assert(calls[0].Error == nil && *calls[0].Reply.(*int) == 3, "unexpected result 0: %v", calls[0].Error)
+			_assert(conn.CodeOf(calls[1].Error) == conn.InvalidArgument, "expect InvalidArgument, but got %v", calls[1].Error)
+			_assert(conn.CodeOf(calls[2].Error) == conn.NotFound, "expect NotFound, but got %v", calls[2].Error)
+			_assert(calls[3].Error == nil && *calls[3].Reply.(*int) == 7, "unexpected result 3: %v", calls[3].Error)
+			_assert(conn.CodeOf(calls[4].Error) == conn.NotFound, "expect NotFound, but got %v", calls[4].Error)
+
+			// 每一项都单独经过拦截器
+			mu.Lock()
+			_assert(intercepted["Calc.Sum"] == 2 && intercepted["Users.Get"] == 2 && len(intercepted) == 2,
+				"expect each item to be intercepted, but got %v", intercepted)
+			mu.Unlock()
+
+			// 批量调用之后，同一个连接上的普通调用不受影响
+			var sum int
+			err = c.Call(ctx, "Calc.Sum", &CalcArgs{Num1: 5, Num2: 6}, &sum)
+			_assert(err == nil && sum == 11, "failed to call Calc.Sum after batch: %v", err)
+		})
+	}
+
+	// 超过处理时间后立即回复，已经完成的项正常返回，未完成的项回复超时错误
+	t.Run("deadline", func(t *testing.T) {
+		c, err := client.Dial("tcp", addr, &conn.Option{HandleTimeout: time.Millisecond * 100})
+		_assert(err == nil, "dial error: %v", err)
+		defer func() { _ = c.Close() }()
+		calls := []*client.BatchCall{
+			{ServiceMethod: "Calc.Sum", Args: &CalcArgs{Num1: 1, Num2: 2}, Reply: new(int)},
+			{ServiceMethod: "Sleeper.Block", Args: time.Second, Reply: new(int)},
+			{ServiceMethod: "Sleeper.Sleep", Args: time.Second, Reply: new(int)},
+		}
+		start := time.Now()
+		err = c.Batch(context.Background(), calls)
+		_assert(err == nil && time.Since(start) < time.Millisecond*500, "expect a reply at the deadline, but got %v", err)
+		_assert(calls[0].Error == nil && *calls[0].Reply.(*int) == 3, "unexpected result 0: %v", calls[0].Error)
+		_assert(conn.CodeOf(calls[1].Error) == conn.DeadlineExceeded, "expect DeadlineExceeded, but got %v", calls[1].Error)
+		_assert(conn.CodeOf(calls[2].Error) == conn.DeadlineExceeded, "expect DeadlineExceeded, but got %v", calls[2].Error)
+	})
+
+	t.Run("not negotiated", func(t *testing.T) {
+		c, _ := client.Dial("tcp", addr, &conn.Option{Capabilities: conn.SupportedCapabilities &^ conn.CapBatch})
+		defer func() { _ = c.Close() }()
+		calls := []*client.BatchCall{{ServiceMethod: "Calc.Sum", Args: &CalcArgs{}, Reply: new(int)}}
+		err := c.Batch(context.Background(), calls)
+		_assert(conn.CodeOf(err) == conn.Unimplemented && calls[0].Error == err, "expect Unimplemented, but got %v", err)
+	})
+}
+
+// TestClient_BatchWindow 批量请求中的项并发执行，但同时执行的项数不超过连接的窗口大小
+func TestClient_BatchWindow(t *testing.T) {
+	slow, addr := startSlowServer(t, 4)
+	c, err := client.Dial("tcp", addr)
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = c.Close() }()
+
+	calls := make([]*client.BatchCall, 16)
+	for i := range calls {
+		calls[i] = &client.BatchCall{ServiceMethod: "Slow.Wait", Args: i, Reply: new(int)}
+	}
+	done := make(chan error, 1)
+	go func() { done <- c.Batch(context.Background(), calls) }()
+
+	slow.waitActive(t, 4)
+	time.Sleep(time.Millisecond * 50)
+	_, maxActive := slow.stats()
+	_assert(maxActive == 4, "expect at most 4 items in progress, but got %d", maxActive)
+
+	close(slow.release)
+	err = <-done
+	_assert(err == nil, "failed to call batch: %v", err)
+	for i, call := range calls {
+		_assert(call.Error == nil && *call.Reply.(*int) == i, "unexpected result %d: %v", i, call.Error)
+	}
+}
+
+// TestClient_BatchCancel 取消批量调用时，服务端取消其中所有的项，不再占用窗口
+func TestClient_BatchCancel(t *testing.T) {
+	slow, addr := startSlowServer(t, 4)
+	c, err := client.Dial("tcp", addr)
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = c.Close() }()
+
+	calls := make([]*client.BatchCall, 8)
+	for i := range calls {
+		calls[i] = &client.BatchCall{ServiceMethod: "Slow.Wait", Args: i, Reply: new(int)}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- c.Batch(ctx, calls) }()
+
+	slow.waitActive(t, 4)
+	cancel()
+	err = <-done
+	_assert(conn.CodeOf(err) == conn.Canceled, "expect Canceled, but got %v", err)
+	slow.waitActive(t, 0)
+	_, maxActive := slow.stats()
+	_assert(maxActive == 4, "expect no more items to start after cancellation, but got %d", maxActive)
+}
@@ -0,0 +1,50 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"fastRPC/client"
+	"fastRPC/conn"
+	"fastRPC/server"
+	"testing"
+	"time"
+)
+
+type Users int
+
+func (u *Users) Get(id int, reply *string) error {
+	if id <= 0 {
+		return &conn.Error{Code: conn.InvalidArgument, Message: "id must be positive", Details: map[string]string{"field": "id"}}
+	}
+	return conn.Errorf(conn.NotFound, "user %d not found (100%%)", id)
+}
+
+// TestError_Code 服务方法和框架返回的错误码都可以在客户端通过 errors.As 取得
+func TestError_Code(t *testing.T) {
+	var u Users
+	srv := server.NewServer()
+	_ = srv.Register(&u)
+	c, err := client.Dial("tcp", serve(t, srv))
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = c.Close() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	var reply string
+	var e *conn.Error
+
+	err = c.Call(ctx, "Users.Get", 1, &reply)
+	_assert(errors.As(err, &e) && e.Code == conn.NotFound && e.Message == "user 1 not found (100%)",
+		"unexpected error: %v", err)
+
+	err = c.Call(ctx, "Users.Get", 0, &reply)
+	_assert(errors.As(err, &e) && e.Code == conn.InvalidArgument && e.Details["field"] == "id",
+		"unexpected error: %v", err)
+
+	err = c.Call(ctx, "Users.Delete", 1, &reply)
+	_assert(conn.CodeOf(err) == conn.NotFound, "expect NotFound for an unknown method, but got %v", err)
+	err = c.Call(ctx, "Users.Get", "not a number", &reply)
+	_assert(conn.CodeOf(err) == conn.InvalidArgument, "expect InvalidArgument for a bad body, but got %v", err)
+	err = c.Call(ctx, "Users.Get", 1, &reply)
+	_assert(conn.CodeOf(err) == conn.NotFound, "the connection should still work, but got %v", err)
+}
@@ -0,0 +1,186 @@
+package test
+
+import (
+	"context"
+	"fastRPC/client"
+	"fastRPC/conn"
+	"fastRPC/server"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Slow.Wait 阻塞到 release 被关闭，记录同时处理的最大请求数
+type Slow struct {
+	release chan struct{}
+
+	mu        sync.Mutex
+	active    int
+	maxActive int
+}
+
+func (s *Slow) Wait(ctx context.Context, n int, reply *int) error {
+	s.mu.Lock()
+	s.active++
+	if s.active > s.maxActive {
+		s.maxActive = s.active
+	}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.active--
+		s.mu.Unlock()
+	}()
+
+	select {
+	case <-s.release:
+		*reply = n
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Slow) stats() (active, maxActive int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.active, s.maxActive
+}
+
+// waitActive 等待服务端同时处理的请求数达到 n
+func (s *Slow) waitActive(t *testing.T, n int) {
+	deadline := time.Now().Add(time.Second)
+	for {
+		if active, _ := s.stats(); active == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expect %d requests in progress", n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func startSlowServer(t *testing.T, window int) (*Slow, string) {
+	slow := &Slow{release: make(chan struct{})}
+	srv := server.NewServer()
+	srv.SetMaxConcurrentRequests(window)
+	_ = srv.Register(slow)
+	return slow, serve(t, srv)
+}
+
+// TestFlowControl_SlowHandler 服务方法处理得很慢时，客户端等待额度，服务端同时处理的请求数不超过窗口大小
+func TestFlowControl_SlowHandler(t *testing.T) {
+	slow, addr := startSlowServer(t, 4)
+	c, err := client.Dial("tcp", addr)
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = c.Close() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	const n = 50
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			var reply int
+			err := c.Call(ctx, "Slow.Wait", i, &reply)
+			if err == nil && reply != i {
+				err = conn.Errorf(conn.Internal, "expect %d, but got %d", i, reply)
+			}
+			errs <- err
+		}(i)
+	}
+
+	slow.waitActive(t, 4)
+	time.Sleep(time.Millisecond * 100)
+	_, maxActive := slow.stats()
+	_assert(maxActive == 4, "expect at most 4 requests in progress, but got %d", maxActive)
+
+	close(slow.release)
+	for i := 0; i < n; i++ {
+		err := <-errs
+		_assert(err == nil, "failed to call Slow.Wait: %v", err)
+	}
+	_, maxActive = slow.stats()
+	_assert(maxActive == 4, "expect at most 4 requests in progress, but got %d", maxActive)
+
+	t.Run("deadline", func(t *testing.T) {
+		slow.release = make(chan struct{})
+		defer close(slow.release)
+		for i := 0; i < 4; i++ {
+			go func() { _ = c.Call(ctx, "Slow.Wait", 0, new(int)) }()
+		}
+		slow.waitActive(t, 4)
+
+		// 窗口已满，等待额度时 ctx 超时
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+		defer cancel()
+		err := c.Call(ctx, "Slow.Wait", 0, new(int))
+		_assert(conn.CodeOf(err) == conn.DeadlineExceeded, "expect DeadlineExceeded, but got %v", err)
+
+		// Go 不等待额度，立即返回 ResourceExhausted
+		select {
+		case call := <-c.Go("Slow.Wait", 0, new(int), nil).Done:
+			_assert(conn.CodeOf(call.Error) == conn.ResourceExhausted, "expect ResourceExhausted, but got %v", call.Error)
+		case <-time.After(time.Second):
+			t.Fatal("Go blocked on the flow control window")
+		}
+	})
+}
+
+// TestFlowControl_NotNegotiated 没有协商 CapFlowControl 的客户端不会等待，超出窗口的请求被拒绝
+func TestFlowControl_NotNegotiated(t *testing.T) {
+	slow, addr := startSlowServer(t, 2)
+	c, err := client.Dial("tcp", addr, &conn.Option{Capabilities: conn.SupportedCapabilities &^ conn.CapFlowControl})
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = c.Close() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() { errs <- c.Call(ctx, "Slow.Wait", 0, new(int)) }()
+	}
+	slow.waitActive(t, 2)
+
+	err = c.Call(ctx, "Slow.Wait", 0, new(int))
+	_assert(conn.CodeOf(err) == conn.ResourceExhausted, "expect ResourceExhausted, but got %v", err)
+
+	close(slow.release)
+	for i := 0; i < 2; i++ {
+		err := <-errs
+		_assert(err == nil, "failed to call Slow.Wait: %v", err)
+	}
+}
+
+// TestFlowControl_TimeoutHoldsWindow 请求超时后，无法被打断的服务方法返回之前仍然占用窗口
+func TestFlowControl_TimeoutHoldsWindow(t *testing.T) {
+	srv := server.NewServer()
+	srv.SetMaxConcurrentRequests(1)
+	_ = srv.Register(new(Sleeper))
+	addr := serve(t, srv)
+	c, err := client.Dial("tcp", addr, &conn.Option{Capabilities: conn.SupportedCapabilities &^ conn.CapFlowControl})
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = c.Close() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	err = c.Call(ctx, "Sleeper.Block", time.Millisecond*300, new(int))
+	_assert(conn.CodeOf(err) == conn.DeadlineExceeded, "expect DeadlineExceeded, but got %v", err)
+
+	err = c.Call(context.Background(), "Sleeper.Sleep", time.Duration(0), new(int))
+	_assert(conn.CodeOf(err) == conn.ResourceExhausted, "expect ResourceExhausted, but got %v", err)
+
+	// Sleeper.Block 返回之后额度被归还
+	deadline := time.Now().Add(time.Second)
+	for {
+		err = c.Call(context.Background(), "Sleeper.Sleep", time.Duration(0), new(int))
+		if err == nil || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	_assert(err == nil, "failed to call Sleeper.Sleep: %v", err)
+}
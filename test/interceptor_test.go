@@ -0,0 +1,96 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"fastRPC/client"
+	"fastRPC/metadata"
+	"fastRPC/server"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// serve 在随机端口上启动 srv，返回监听的地址
+func serve(t *testing.T, srv *server.Server) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("network error:", err)
+	}
+	go srv.Accept(l)
+	return l.Addr().String()
+}
+
+// TestServer_Interceptor 拦截器按注册顺序嵌套，可以读取请求的信息和响应，也可以直接拒绝请求
+func TestServer_Interceptor(t *testing.T) {
+	var calc Calc
+	srv := server.NewServer()
+	_ = srv.Register(&calc)
+
+	var mu sync.Mutex
+	var trace []string
+	var reply interface{}
+	appendTrace := func(s string) {
+		mu.Lock()
+		defer mu.Unlock()
+		trace = append(trace, s)
+	}
+	record := func(name string) server.UnaryServerInterceptor {
+		return func(ctx context.Context, info *server.UnaryServerInfo, args interface{}, handler server.UnaryHandler) error {
+			appendTrace(name + ">")
+			err := handler(ctx, args)
+			appendTrace("<" + name)
+			return err
+		}
+	}
+	auth := func(ctx context.Context, info *server.UnaryServerInfo, args interface{}, handler server.UnaryHandler) error {
+		_assert(info.ServiceMethod == "Calc.Sum" && info.Service == "Calc" && info.Method == "Sum",
+			"unexpected info: %+v", info)
+		if info.Metadata.Get("token") != "secret" {
+			return errors.New("permission denied")
+		}
+		err := handler(ctx, args)
+		mu.Lock()
+		reply = info.Reply
+		mu.Unlock()
+		return err
+	}
+	srv.Use(record("first"), record("second"))
+	srv.Use(auth)
+
+	c, err := client.Dial("tcp", serve(t, srv))
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = c.Close() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	t.Run("order", func(t *testing.T) {
+		mu.Lock()
+		trace = nil
+		mu.Unlock()
+		var sum int
+		err := c.Call(metadata.AppendToOutgoingContext(ctx, "token", "secret"), "Calc.Sum", &CalcArgs{Num1: 1, Num2: 2}, &sum)
+		_assert(err == nil && sum == 3, "failed to call Calc.Sum: %v", err)
+		mu.Lock()
+		defer mu.Unlock()
+		_assert(strings.Join(trace, " ") == "first> second> <second <first", "unexpected trace: %v", trace)
+		p, ok := reply.(*int)
+		_assert(ok && *p == 3, "interceptor should see the reply, but got %v", reply)
+	})
+
+	t.Run("reject", func(t *testing.T) {
+		mu.Lock()
+		trace = nil
+		mu.Unlock()
+		var sum int
+		err := c.Call(ctx, "Calc.Sum", &CalcArgs{Num1: 1, Num2: 2}, &sum)
+		_assert(err != nil && strings.Contains(err.Error(), "permission denied"), "expect permission denied, but got %v", err)
+		_assert(sum == 0, "service method should not be called")
+		mu.Lock()
+		defer mu.Unlock()
+		_assert(len(trace) == 4, "outer interceptors should still run: %v", trace)
+	})
+}
@@ -0,0 +1,166 @@
+package test
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fastRPC/client"
+	"fastRPC/conn"
+	"fastRPC/server"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+/*
+使用手写的 socket 客户端（不依赖 conn 包的编解码器）与 FastRPC 服务端通信，
+验证 JsonType 在网络上传输的确实是 JSON 文本，非 Go 语言的客户端也能按同样的方式调用：
+1. 发送前导 | Magic uint32 | Version uint8 | OptionLen uint32 | 和 JSON 编码的 Option，并读取服务端回复的 Option；
+2. 每个 Header 和 Body 都是一帧 | Length uint32 | Flags uint8 | JSON |。
+*/
+
+type Calc int
+
+type CalcArgs struct{ Num1, Num2 int }
+
+func (c *Calc) Sum(args CalcArgs, reply *int) error {
+	*reply = args.Num1 + args.Num2
+	return nil
+}
+
+func _assert(condition bool, msg string, v ...interface{}) {
+	if !condition {
+		panic(fmt.Sprintf("assertion failed: "+msg, v...))
+	}
+}
+
+func startJsonServer(t *testing.T) string {
+	var calc Calc
+	srv := server.NewServer()
+	_ = srv.Register(&calc)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("network error:", err)
+	}
+	go srv.Accept(l)
+	return l.Addr().String()
+}
+
+// writeRawFrame 按 | Length | Flags | Payload | 的格式写出一帧
+func writeRawFrame(w io.Writer, payload string) {
+	var hdr [5]byte
+	binary.BigEndian.PutUint32(hdr[:4], uint32(len(payload)))
+	_, _ = w.Write(append(hdr[:], payload...))
+}
+
+// readRawFrame 读取一帧，返回帧的内容
+func readRawFrame(r io.Reader) string {
+	var hdr [5]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return ""
+	}
+	payload := make([]byte, binary.BigEndian.Uint32(hdr[:4]))
+	_, _ = io.ReadFull(r, payload)
+	return string(payload)
+}
+
+// handshakeRaw 发送前导和 Option，返回服务端回复的 Option
+func handshakeRaw(nc net.Conn, opt string) string {
+	preamble := make([]byte, 9)
+	binary.BigEndian.PutUint32(preamble[0:4], conn.MagicNumber)
+	preamble[4] = 1
+	binary.BigEndian.PutUint32(preamble[5:9], uint32(len(opt)))
+	_, _ = nc.Write(append(preamble, opt...))
+
+	_, _ = io.ReadFull(nc, preamble)
+	_assert(binary.BigEndian.Uint32(preamble[0:4]) == conn.MagicNumber, "unexpected magic number")
+	echo := make([]byte, binary.BigEndian.Uint32(preamble[5:9]))
+	_, _ = io.ReadFull(nc, echo)
+	return string(echo)
+}
+
+// dialRawJson 完成前导和 Option 的协议交换，返回原始连接
+func dialRawJson(t *testing.T, addr string) net.Conn {
+	nc, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+
+	opt := fmt.Sprintf(`{"MagicNumber":%d,"ConnType":"application/json","Version":1}`, conn.MagicNumber)
+	echo := handshakeRaw(nc, opt)
+	_assert(strings.Contains(echo, `"application/json"`) && !strings.Contains(echo, `"Error"`),
+		"unexpected option echo: %q", echo)
+	return nc
+}
+
+func TestJsonConn_RawSocket(t *testing.T) {
+	addr := startJsonServer(t)
+	nc := dialRawJson(t, addr)
+	defer func() { _ = nc.Close() }()
+
+	t.Run("call", func(t *testing.T) {
+		writeRawFrame(nc, `{"ServiceMethod":"Calc.Sum","Seq":1}`)
+		writeRawFrame(nc, `{"Num1":3,"Num2":4}`)
+
+		var h struct {
+			ServiceMethod string
+			Seq           uint64
+			Error         string
+		}
+		header := readRawFrame(nc)
+		_assert(json.Unmarshal([]byte(header), &h) == nil, "header is not json: %q", header)
+		_assert(h.Seq == 1 && h.Error == "", "unexpected header: %+v", h)
+		body := readRawFrame(nc)
+		_assert(body == "7", "expect body 7, but got %q", body)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		writeRawFrame(nc, `{"ServiceMethod":"Calc.Mul","Seq":2}`)
+		writeRawFrame(nc, `{"Num1":3,"Num2":4}`)
+
+		var h struct {
+			Seq   uint64
+			Error string
+		}
+		header := readRawFrame(nc)
+		_assert(json.Unmarshal([]byte(header), &h) == nil, "header is not json: %q", header)
+		_assert(h.Seq == 2 && strings.Contains(h.Error, "can't find method"), "unexpected header: %+v", h)
+		body := readRawFrame(nc)
+		_assert(json.Valid([]byte(body)), "body is not json: %q", body)
+	})
+}
+
+// TestJsonConn_Client 服务端返回错误时，Go 客户端需要通过 ReadBody(nil) 丢弃消息体，后续的调用不受影响
+func TestJsonConn_Client(t *testing.T) {
+	addr := startJsonServer(t)
+	c, err := client.Dial("tcp", addr, &conn.Option{ConnType: conn.JsonType})
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = c.Close() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	var reply int
+	err = c.Call(ctx, "Calc.Mul", &CalcArgs{Num1: 1, Num2: 2}, &reply)
+	_assert(err != nil && strings.Contains(err.Error(), "can't find method"), "expect a method error")
+	err = c.Call(ctx, "Calc.Sum", &CalcArgs{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "failed to call Calc.Sum: %v", err)
+}
+
+// TestJsonConn_Reject 协议版本不受支持时，服务端在回复的 Option 中给出可读的错误信息
+func TestJsonConn_Reject(t *testing.T) {
+	addr := startJsonServer(t)
+	nc, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	defer func() { _ = nc.Close() }()
+
+	echo := handshakeRaw(nc, `{"ConnType":"application/json"}`)
+	var opt struct{ Error string }
+	_assert(json.Unmarshal([]byte(echo), &opt) == nil, "option echo is not json: %q", echo)
+	_assert(strings.Contains(opt.Error, "unsupported protocol version"), "unexpected error: %q", opt.Error)
+}
@@ -0,0 +1,75 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"fastRPC/client"
+	"fastRPC/conn"
+	"fastRPC/metadata"
+	"fastRPC/server"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Tagger.Tag 返回请求元数据中的 tag，并把它设置为响应的元数据
+type Tagger struct{}
+
+func (t *Tagger) Tag(ctx context.Context, _ int, tag *string) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	*tag = md.Get("tag")
+	return metadata.SetReply(ctx, metadata.Pairs("tag", "reply"))
+}
+
+// TestMetadata_NotNegotiated 没有协商 CapMetadata 时，双方都不发送也不接收元数据
+func TestMetadata_NotNegotiated(t *testing.T) {
+	srv := server.NewServer()
+	_ = srv.Register(new(Tagger))
+	addr := serve(t, srv)
+	caps := conn.SupportedCapabilities &^ conn.CapMetadata
+
+	// 对端不遵守协商结果，仍然在请求中携带元数据
+	t.Run("raw", func(t *testing.T) {
+		nc := dialRawCaps(t, addr, caps)
+		defer func() { _ = nc.Close() }()
+
+		writeRawFrame(nc, `{"ServiceMethod":"Tagger.Tag","Seq":1,"Metadata":{"tag":"request"}}`)
+		writeRawFrame(nc, `1`)
+		var h conn.Header
+		header := readRawFrame(nc)
+		_assert(json.Unmarshal([]byte(header), &h) == nil, "header is not json: %q", header)
+		_assert(h.Seq == 1 && h.Error == "" && h.Metadata == nil, "unexpected header: %q", header)
+		body := readRawFrame(nc)
+		_assert(body == `""`, "the handler should see no metadata, but got %s", body)
+	})
+
+	t.Run("client", func(t *testing.T) {
+		c, err := client.Dial("tcp", addr, &conn.Option{Capabilities: caps})
+		_assert(err == nil, "dial error: %v", err)
+		defer func() { _ = c.Close() }()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		var reply metadata.MD
+		var tag string
+		ctx = metadata.WithReplyHolder(metadata.AppendToOutgoingContext(ctx, "tag", "request"), &reply)
+		err = c.Call(ctx, "Tagger.Tag", 1, &tag)
+		_assert(err == nil && tag == "", "unexpected reply: %q, %v", tag, err)
+		_assert(reply == nil, "expect no reply metadata, but got %v", reply)
+	})
+}
+
+// dialRawCaps 以 caps 能力集合完成 JSON 连接的协议交换，返回原始连接
+func dialRawCaps(t *testing.T, addr string, caps conn.Capability) net.Conn {
+	nc, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	opt := fmt.Sprintf(`{"MagicNumber":%d,"ConnType":"application/json","Version":%d,"Capabilities":%d}`,
+		conn.MagicNumber, conn.ProtocolVersion, caps)
+	echo := handshakeRaw(nc, opt)
+	_assert(!strings.Contains(echo, `"Error"`), "unexpected option echo: %q", echo)
+	return nc
+}
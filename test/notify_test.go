@@ -0,0 +1,99 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"fastRPC/client"
+	"fastRPC/conn"
+	"fastRPC/load_balance/xclient"
+	"fastRPC/metadata"
+	"fastRPC/server"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// Inbox.Put 将收到的通知转发到 ch
+type Inbox struct {
+	ch chan string
+}
+
+func (i *Inbox) Put(ctx context.Context, msg string, reply *int) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	i.ch <- msg + md.Get("from")
+	return nil
+}
+
+func (i *Inbox) expect(t *testing.T, msg string) {
+	select {
+	case got := <-i.ch:
+		_assert(got == msg, "expect %q, but got %q", msg, got)
+	case <-time.After(time.Second):
+		t.Fatalf("expect notification %q", msg)
+	}
+}
+
+func TestClient_Notify(t *testing.T) {
+	inbox := &Inbox{ch: make(chan string, 10)}
+	var calc Calc
+	srv := server.NewServer()
+	_ = srv.Register(inbox)
+	_ = srv.Register(&calc)
+	addr := serve(t, srv)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	t.Run("client", func(t *testing.T) {
+		c, err := client.Dial("tcp", addr)
+		_assert(err == nil, "dial error: %v", err)
+		defer func() { _ = c.Close() }()
+
+		err = c.Notify(metadata.AppendToOutgoingContext(ctx, "from", "@client"), "Inbox.Put", "hello")
+		_assert(err == nil, "failed to notify: %v", err)
+		inbox.expect(t, "hello@client")
+
+		// 服务端的错误不会返回给调用方，连接上之后的调用不受影响
+		_assert(c.Notify(ctx, "Inbox.Get", "lost") == nil, "notify should not wait for the server")
+		var sum int
+		err = c.Call(ctx, "Calc.Sum", &CalcArgs{Num1: 1, Num2: 2}, &sum)
+		_assert(err == nil && sum == 3, "failed to call Calc.Sum after notify: %v", err)
+	})
+
+	t.Run("xclient", func(t *testing.T) {
+		xc := xclient.NewXClient(xclient.NewMultiServerDiscovery([]string{"tcp@" + addr}), xclient.RandomSelect, nil)
+		defer func() { _ = xc.Close() }()
+		_assert(xc.Notify(ctx, "Inbox.Put", "hi") == nil, "failed to notify through xclient")
+		inbox.expect(t, "hi")
+	})
+
+	t.Run("not negotiated", func(t *testing.T) {
+		c, _ := client.Dial("tcp", addr, &conn.Option{Capabilities: conn.SupportedCapabilities &^ conn.CapOneWay})
+		defer func() { _ = c.Close() }()
+		err := c.Notify(ctx, "Inbox.Put", "hello")
+		_assert(conn.CodeOf(err) == conn.Unimplemented, "expect Unimplemented, but got %v", err)
+	})
+
+	// 服务端不回复单向调用，连接上收到的第一个响应属于之后的普通请求
+	t.Run("no response", func(t *testing.T) {
+		nc := dialRawJson(t, addr)
+		defer func() { _ = nc.Close() }()
+
+		writeRawFrame(nc, fmt.Sprintf(`{"ServiceMethod":"Inbox.Put","Kind":%d}`, conn.KindOneWay))
+		writeRawFrame(nc, `"raw"`)
+		writeRawFrame(nc, fmt.Sprintf(`{"ServiceMethod":"Inbox.Missing","Kind":%d}`, conn.KindOneWay))
+		writeRawFrame(nc, `"raw"`)
+		writeRawFrame(nc, `{"ServiceMethod":"Calc.Sum","Seq":1}`)
+		writeRawFrame(nc, `{"Num1":3,"Num2":4}`)
+		inbox.expect(t, "raw")
+
+		var h struct {
+			Seq   uint64
+			Error string
+		}
+		header := readRawFrame(nc)
+		_assert(json.Unmarshal([]byte(header), &h) == nil, "header is not json: %q", header)
+		_assert(h.Seq == 1 && h.Error == "", "expect the response of Calc.Sum, but got %+v", h)
+		_assert(readRawFrame(nc) == "7", "expect body 7")
+	})
+}
@@ -0,0 +1,71 @@
+package test
+
+import (
+	"context"
+	"fastRPC/client"
+	"fastRPC/conn"
+	"fastRPC/server"
+	"strings"
+	"testing"
+	"time"
+)
+
+type Divider int
+
+func (d *Divider) Div(args CalcArgs, reply *int) error {
+	*reply = args.Num1 / args.Num2
+	return nil
+}
+
+// TestServer_Panic 服务方法发生 panic 时，调用方收到包含方法名的错误，服务端和连接都不受影响
+func TestServer_Panic(t *testing.T) {
+	var d Divider
+	srv := server.NewServer()
+	_ = srv.Register(&d)
+	c, err := client.Dial("tcp", serve(t, srv))
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = c.Close() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	var reply int
+	err = c.Call(ctx, "Divider.Div", &CalcArgs{Num1: 1, Num2: 0}, &reply)
+	_assert(err != nil && strings.Contains(err.Error(), "panic in Divider.Div"), "expect a panic error, but got %v", err)
+	_assert(conn.CodeOf(err) == conn.Internal, "expect Internal, but got %s", conn.CodeOf(err))
+	err = c.Call(ctx, "Divider.Div", &CalcArgs{Num1: 4, Num2: 2}, &reply)
+	_assert(err == nil && reply == 2, "failed to call Divider.Div after panic: %v", err)
+}
+
+// TestServer_InterceptorPanic 拦截器发生 panic 时同样回复 Internal 错误，批量请求中的每一项也不例外
+func TestServer_InterceptorPanic(t *testing.T) {
+	var d Divider
+	srv := server.NewServer()
+	_ = srv.Register(&d)
+	srv.Use(func(ctx context.Context, info *server.UnaryServerInfo, args interface{}, handler server.UnaryHandler) error {
+		if args.(CalcArgs).Num2 < 0 {
+			panic("negative divisor")
+		}
+		return handler(ctx, args)
+	})
+	c, err := client.Dial("tcp", serve(t, srv))
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = c.Close() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	var reply int
+	err = c.Call(ctx, "Divider.Div", CalcArgs{Num1: 1, Num2: -1}, &reply)
+	_assert(err != nil && strings.Contains(err.Error(), "negative divisor"), "expect a panic error, but got %v", err)
+	_assert(conn.CodeOf(err) == conn.Internal, "expect Internal, but got %s", conn.CodeOf(err))
+
+	calls := []*client.BatchCall{
+		{ServiceMethod: "Divider.Div", Args: CalcArgs{Num1: 4, Num2: -1}, Reply: new(int)},
+		{ServiceMethod: "Divider.Div", Args: CalcArgs{Num1: 4, Num2: 2}, Reply: new(int)},
+	}
+	err = c.Batch(ctx, calls)
+	_assert(err == nil, "failed to call Batch: %v", err)
+	_assert(conn.CodeOf(calls[0].Error) == conn.Internal, "expect Internal, but got %v", calls[0].Error)
+	_assert(calls[1].Error == nil && *calls[1].Reply.(*int) == 2, "expect other calls to succeed, but got %v", calls[1].Error)
+}
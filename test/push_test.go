@@ -0,0 +1,159 @@
+package test
+
+import (
+	"context"
+	"fastRPC/client"
+	"fastRPC/conn"
+	"fastRPC/server"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Cache.Delete 先向调用方推送进度，再向所有订阅了 invalidate 的连接广播被删除的 key，返回收到广播的连接数
+type Cache struct {
+	srv *server.Server
+}
+
+func (c *Cache) Delete(ctx context.Context, key string, reply *int) error {
+	if err := server.Push(ctx, "progress", "deleting "+key); err != nil {
+		return err
+	}
+	*reply = c.srv.Publish("invalidate", key)
+	return nil
+}
+
+// collector 收集推送的消息
+type collector struct {
+	mu   sync.Mutex
+	msgs []string
+}
+
+func (c *collector) add(msg string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.msgs = append(c.msgs, msg)
+}
+
+// wait 等待收到 n 条消息
+func (c *collector) wait(t *testing.T, n int) []string {
+	deadline := time.Now().Add(time.Second)
+	for {
+		c.mu.Lock()
+		msgs := append([]string(nil), c.msgs...)
+		c.mu.Unlock()
+		if len(msgs) >= n {
+			return msgs
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expect %d pushed messages, but got %v", n, msgs)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestServer_Push(t *testing.T) {
+	srv := server.NewServer()
+	_ = srv.Register(&Cache{srv: srv})
+	var calc Calc
+	_ = srv.Register(&calc)
+	addr := serve(t, srv)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	// subscribe 订阅 invalidate，之后的一次调用保证服务端已经处理了订阅
+	subscribe := func(c *client.Client, col *collector) {
+		err := c.OnPush("invalidate", func(key string) { col.add(key) })
+		_assert(err == nil, "failed to subscribe: %v", err)
+		_ = c.Call(ctx, "Calc.Sum", &CalcArgs{}, new(int))
+	}
+
+	var a, b collector
+	ca, _ := client.Dial("tcp", addr)
+	defer func() { _ = ca.Close() }()
+	cb, _ := client.Dial("tcp", addr, &conn.Option{ConnType: conn.JsonType})
+	defer func() { _ = cb.Close() }()
+	subscribe(ca, &a)
+	subscribe(cb, &b)
+
+	var progress collector
+	c, _ := client.Dial("tcp", addr)
+	defer func() { _ = c.Close() }()
+	_assert(c.OnPush("progress", func(msg *string) { progress.add(*msg) }) == nil, "failed to register handler")
+
+	var n int
+	err := c.Call(ctx, "Cache.Delete", "user:1", &n)
+	_assert(err == nil && n == 2, "expect 2 subscribers, but got %d, %v", n, err)
+	// 推送与响应在同一个连接上按顺序到达，调用返回时推送已经被处理
+	msgs := progress.wait(t, 1)
+	_assert(len(msgs) == 1 && msgs[0] == "deleting user:1", "unexpected progress: %v", msgs)
+	_assert(a.wait(t, 1)[0] == "user:1" && b.wait(t, 1)[0] == "user:1", "subscribers should receive the invalidation")
+
+	t.Run("unsubscribe", func(t *testing.T) {
+		_assert(ca.OnPush("invalidate", nil) == nil, "failed to unsubscribe")
+		err := ca.Call(ctx, "Cache.Delete", "user:2", &n)
+		_assert(err == nil && n == 1, "expect 1 subscriber after unsubscribing, but got %d, %v", n, err)
+		_assert(b.wait(t, 2)[1] == "user:2", "the other subscriber should still receive the invalidation")
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		err := c.OnPush("invalidate", func(a, b string) {})
+		_assert(conn.CodeOf(err) == conn.InvalidArgument, "expect InvalidArgument, but got %v", err)
+		err = c.OnPush("", func(string) {})
+		_assert(conn.CodeOf(err) == conn.InvalidArgument, "expect InvalidArgument for an empty topic, but got %v", err)
+		err = server.Push(context.Background(), "progress", "lost")
+		_assert(conn.CodeOf(err) == conn.FailedPrecondition, "expect FailedPrecondition, but got %v", err)
+	})
+
+	t.Run("too many topics", func(t *testing.T) {
+		c, _ := client.Dial("tcp", addr)
+		defer func() { _ = c.Close() }()
+		for i := 0; i < conn.MaxTopics; i++ {
+			_assert(c.OnPush(fmt.Sprintf("topic-%d", i), func(string) {}) == nil, "failed to subscribe topic %d", i)
+		}
+		err := c.OnPush("one-more", func(string) {})
+		_assert(conn.CodeOf(err) == conn.ResourceExhausted, "expect ResourceExhausted, but got %v", err)
+		_assert(c.OnPush("topic-0", func(*string) {}) == nil, "replacing a handler should not count as a new topic")
+	})
+}
+
+// TestServer_PublishStuck 一个订阅者不读取数据时，Publish 不会被阻塞，其他订阅者照常收到消息
+func TestServer_PublishStuck(t *testing.T) {
+	srv := server.NewServer()
+	var calc Calc
+	_ = srv.Register(&calc)
+	addr := serve(t, srv)
+
+	// 手写的 JSON 客户端订阅 invalidate，确认订阅生效后不再读取
+	nc, err := net.Dial("tcp", addr)
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = nc.Close() }()
+	opt := fmt.Sprintf(`{"MagicNumber":%d,"ConnType":"application/json","Version":1,"Capabilities":%d}`, conn.MagicNumber, conn.CapPush)
+	_assert(strings.Contains(handshakeRaw(nc, opt), fmt.Sprintf(`"Capabilities":%d`, conn.CapPush)), "expect CapPush to be negotiated")
+	writeRawFrame(nc, fmt.Sprintf(`{"ServiceMethod":"invalidate","Kind":%d}`, conn.KindSubscribe))
+	writeRawFrame(nc, "null")
+	writeRawFrame(nc, `{"ServiceMethod":"Calc.Sum","Seq":1}`)
+	writeRawFrame(nc, `{"Num1":1,"Num2":2}`)
+	_assert(strings.Contains(readRawFrame(nc), `"Seq":1`) && readRawFrame(nc) == "3", "failed to call Calc.Sum")
+
+	// 消息远超过 socket 的缓冲区和发送队列，写操作阻塞之后新的消息被丢弃
+	big := strings.Repeat("x", 256<<10)
+	start, queued := time.Now(), 0
+	for i := 0; i < 256; i++ {
+		queued += srv.Publish("invalidate", big)
+	}
+	_assert(time.Since(start) < time.Second, "expect Publish not to block on a stuck subscriber, took %s", time.Since(start))
+	_assert(queued < 256, "expect some messages to the stuck subscriber to be dropped")
+
+	var b collector
+	cb, _ := client.Dial("tcp", addr)
+	defer func() { _ = cb.Close() }()
+	_assert(cb.OnPush("invalidate", func(key string) { b.add(key) }) == nil, "failed to subscribe")
+	_ = cb.Call(context.Background(), "Calc.Sum", &CalcArgs{}, new(int))
+	_assert(srv.Publish("invalidate", "user:1") >= 1, "expect the other subscriber to receive the message")
+	_assert(b.wait(t, 1)[0] == "user:1", "the other subscriber should receive the invalidation")
+}
@@ -0,0 +1,73 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"fastRPC/client"
+	"fastRPC/server"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestReconnectClient 服务端重启后，ReconnectClient 自动重连，断开时未完成的请求返回 ErrConnLost
+func TestReconnectClient(t *testing.T) {
+	start := func(addr string) *server.Server {
+		var sleeper Sleeper
+		srv := server.NewServer()
+		_ = srv.Register(&sleeper)
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			t.Fatal("network error:", err)
+		}
+		go srv.Accept(l)
+		return srv
+	}
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := l.Addr().String()
+	_ = l.Close()
+	srv := start(addr)
+
+	var mu sync.Mutex
+	var states []string
+	d := &client.Dialer{
+		Backoff: &client.Backoff{Initial: time.Millisecond * 10, Max: time.Millisecond * 50, Multiplier: 2, Jitter: 0.2},
+		OnStateChange: func(state client.ConnState) {
+			mu.Lock()
+			defer mu.Unlock()
+			states = append(states, state.String())
+		},
+	}
+	rc, err := d.DialReconnect("tcp@" + addr)
+	_assert(err == nil, "dial error: %v", err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
+	err = rc.Call(ctx, "Sleeper.Sleep", time.Millisecond, new(int))
+	_assert(err == nil, "failed to call Sleeper.Sleep: %v", err)
+
+	call := rc.Go("Sleeper.Sleep", time.Second, new(int), nil)
+	time.Sleep(time.Millisecond * 50)
+	_ = srv.Close()
+	call = <-call.Done
+	_assert(errors.Is(call.Error, client.ErrConnLost), "expect ErrConnLost, but got %v", call.Error)
+
+	// 服务端重启之前，重连会失败若干次
+	time.Sleep(time.Millisecond * 100)
+	srv = start(addr)
+	defer func() { _ = srv.Close() }()
+	err = rc.Call(ctx, "Sleeper.Sleep", time.Millisecond, new(int))
+	_assert(err == nil, "expect the call to succeed after reconnecting, but got %v", err)
+
+	_ = rc.Close()
+	err = rc.Call(ctx, "Sleeper.Sleep", time.Millisecond, new(int))
+	_assert(err == client.ErrConnClosed, "expect ErrConnClosed after Close, but got %v", err)
+
+	mu.Lock()
+	defer mu.Unlock()
+	trace := strings.Join(states, " ")
+	_assert(strings.HasPrefix(trace, "CONNECTING READY TRANSIENT_FAILURE CONNECTING TRANSIENT_FAILURE") &&
+		strings.HasSuffix(trace, "CONNECTING READY SHUTDOWN"), "unexpected state transitions: %s", trace)
+}
@@ -0,0 +1,130 @@
+package test
+
+import (
+	"context"
+	"fastRPC/client"
+	"fastRPC/conn"
+	"fastRPC/metadata"
+	"fastRPC/server"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Registry.Hello 记录发起请求的连接，之后服务端可以通过它调用客户端
+type Registry struct {
+	mu     sync.Mutex
+	agents map[string]*server.Peer
+}
+
+func (r *Registry) Hello(ctx context.Context, id string, reply *bool) error {
+	peer, ok := server.PeerFromContext(ctx)
+	if !ok {
+		return conn.NewError(conn.Internal, "no peer in context")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.agents[id] = peer
+	*reply = true
+	return nil
+}
+
+func (r *Registry) agent(id string) *server.Peer {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.agents[id]
+}
+
+// Agent 注册在客户端的服务
+type Agent struct {
+	ID string
+}
+
+func (a *Agent) Exec(ctx context.Context, cmd string, out *string) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	*out = a.ID + ": " + cmd + md.Get("by")
+	return nil
+}
+
+func (a *Agent) Sleep(ctx context.Context, d time.Duration, out *string) error {
+	select {
+	case <-time.After(d):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func TestPeer_Call(t *testing.T) {
+	registry := &Registry{agents: make(map[string]*server.Peer)}
+	var calc Calc
+	srv := server.NewServer()
+	_ = srv.Register(registry)
+	_ = srv.Register(&calc)
+	addr := serve(t, srv)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
+
+	c, err := client.Dial("tcp", addr)
+	_assert(err == nil, "dial error: %v", err)
+	_assert(c.Register(&Agent{ID: "agent-1"}) == nil, "failed to register service on the client")
+	var ok bool
+	err = c.Call(ctx, "Registry.Hello", "agent-1", &ok)
+	_assert(err == nil && ok, "failed to call Registry.Hello: %v", err)
+	peer := registry.agent("agent-1")
+
+	t.Run("call", func(t *testing.T) {
+		var out string
+		err := peer.Call(metadata.AppendToOutgoingContext(ctx, "by", "@server"), "Agent.Exec", "uptime", &out)
+		_assert(err == nil && out == "agent-1: uptime@server", "unexpected reverse reply: %q, %v", out, err)
+	})
+
+	// 双方同时发起调用，各自的 Seq 空间互不干扰
+	t.Run("both directions", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(2)
+			go func(i int) {
+				defer wg.Done()
+				var sum int
+				err := c.Call(ctx, "Calc.Sum", &CalcArgs{Num1: i, Num2: i}, &sum)
+				_assert(err == nil && sum == 2*i, "failed to call Calc.Sum: %v", err)
+			}(i)
+			go func(i int) {
+				defer wg.Done()
+				var out string
+				cmd := string(rune('a' + i))
+				err := peer.Call(ctx, "Agent.Exec", cmd, &out)
+				_assert(err == nil && out == "agent-1: "+cmd, "unexpected reverse reply: %q, %v", out, err)
+			}(i)
+		}
+		wg.Wait()
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		err := peer.Call(ctx, "Agent.Kill", "", new(string))
+		_assert(conn.CodeOf(err) == conn.NotFound, "expect NotFound, but got %v", err)
+
+		// deadline 随反向调用传给客户端
+		tctx, cancel := context.WithTimeout(ctx, time.Millisecond*50)
+		defer cancel()
+		err = peer.Call(tctx, "Agent.Sleep", time.Second, new(string))
+		_assert(conn.CodeOf(err) == conn.DeadlineExceeded, "expect DeadlineExceeded, but got %v", err)
+	})
+
+	t.Run("closed", func(t *testing.T) {
+		done := make(chan error, 1)
+		go func() { done <- peer.Call(ctx, "Agent.Sleep", time.Second, new(string)) }()
+		time.Sleep(time.Millisecond * 50)
+		_ = c.Close()
+		select {
+		case err := <-done:
+			_assert(err == server.ErrPeerGone, "expect ErrPeerGone, but got %v", err)
+		case <-time.After(time.Second):
+			t.Fatal("reverse call should fail after the connection is closed")
+		}
+		err := peer.Call(ctx, "Agent.Exec", "uptime", new(string))
+		_assert(err == server.ErrPeerGone, "expect ErrPeerGone, but got %v", err)
+	})
+}
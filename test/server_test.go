@@ -1,7 +1,6 @@
 package test
 
 import (
-	"encoding/json"
 	"fastRPC/conn"
 	"fastRPC/server"
 	"fmt"
@@ -13,7 +12,7 @@ import (
 
 /*
 1. 在 startServer 中使用了信道 addr，确保服务端端口监听成功，客户端再发起请求。
-2. 客户端首先发送 Option 进行协议交换，接下来发送消息头 h := &conn.Header{}，和消息体 fastRPC req ${h.Seq}。
+2. 客户端首先发送前导和 Option 进行协议交换，接下来发送消息头 h := &conn.Header{}，和消息体 fastRPC req ${h.Seq}。
 3. 最后解析服务端的响应 reply，并打印出来。
 */
 
@@ -37,9 +36,10 @@ func TestFastRpcServer(t *testing.T) {
 	clientConn, _ := net.Dial("tcp", "127.0.0.1:12345")
 	defer func() { _ = clientConn.Close() }()
 
-	// 设置options
-	_ = json.NewEncoder(clientConn).Encode(conn.DefaultOption)
-	cc := conn.NewGobConn(clientConn)
+	// 设置options，并等待服务端回复 Option
+	_ = conn.WriteOption(clientConn, conn.DefaultOption)
+	_, _ = conn.ReadOption(clientConn)
+	cc := conn.NewGobConn(conn.NewFramer(clientConn))
 
 	// send request & receive response
 	for i := 0; i < 5; i++ {
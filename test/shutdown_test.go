@@ -0,0 +1,118 @@
+package test
+
+import (
+	"context"
+	"fastRPC/client"
+	"fastRPC/server"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Blob.Get 返回 n 字节的字符串，用于填满对端不读取的连接
+type Blob int
+
+func (b *Blob) Get(n int, reply *string) error {
+	*reply = strings.Repeat("x", n)
+	return nil
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	start := func() (*server.Server, string) {
+		var sleeper Sleeper
+		srv := server.NewServer()
+		_ = srv.Register(&sleeper)
+		return srv, serve(t, srv)
+	}
+
+	// 处理中的请求正常完成，之后的请求和连接被拒绝
+	t.Run("graceful", func(t *testing.T) {
+		srv, addr := start()
+		c, err := client.Dial("tcp", addr)
+		_assert(err == nil, "dial error: %v", err)
+		defer func() { _ = c.Close() }()
+
+		call := c.Go("Sleeper.Sleep", time.Millisecond*200, new(int), nil)
+		time.Sleep(time.Millisecond * 50)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+		defer cancel()
+		err = srv.Shutdown(ctx)
+		_assert(err == nil, "expect a graceful shutdown, but got %v", err)
+		call = <-call.Done
+		_assert(call.Error == nil, "expect the call in progress to finish, but got %v", call.Error)
+
+		err = c.Call(ctx, "Sleeper.Sleep", time.Millisecond, new(int))
+		_assert(err == client.ErrConnNotAvailable, "expect no new calls after GOAWAY, but got %v", err)
+		_assert(!c.IsAvailable(), "client should not be available after GOAWAY")
+		_, err = net.DialTimeout("tcp", addr, time.Second)
+		_assert(err != nil, "listener should be closed")
+	})
+
+	// ctx 结束时强制关闭剩余的连接
+	t.Run("deadline", func(t *testing.T) {
+		srv, addr := start()
+		c, err := client.Dial("tcp", addr)
+		_assert(err == nil, "dial error: %v", err)
+		defer func() { _ = c.Close() }()
+
+		call := c.Go("Sleeper.Block", time.Second, new(int), nil)
+		time.Sleep(time.Millisecond * 50)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+		defer cancel()
+		err = srv.Shutdown(ctx)
+		_assert(err == context.DeadlineExceeded, "expect a deadline error, but got %v", err)
+		select {
+		case call = <-call.Done:
+			_assert(call.Error != nil, "expect the call to fail after the connection was closed")
+		case <-time.After(time.Millisecond * 500):
+			t.Fatal("expect the connection to be closed at the deadline")
+		}
+	})
+
+	t.Run("close", func(t *testing.T) {
+		srv, addr := start()
+		c, err := client.Dial("tcp", addr)
+		_assert(err == nil, "dial error: %v", err)
+		defer func() { _ = c.Close() }()
+
+		call := c.Go("Sleeper.Sleep", time.Second, new(int), nil)
+		time.Sleep(time.Millisecond * 50)
+		_ = srv.Close()
+		select {
+		case call = <-call.Done:
+			_assert(call.Error != nil, "expect the call to fail after Close")
+		case <-time.After(time.Millisecond * 500):
+			t.Fatal("expect the connection to be closed immediately")
+		}
+	})
+
+	// 对端不读数据，回复阻塞在写操作上时，Shutdown 仍然在 ctx 结束时返回
+	t.Run("stuck", func(t *testing.T) {
+		var blob Blob
+		srv := server.NewServer()
+		_ = srv.Register(&blob)
+		nc := dialRawJson(t, serve(t, srv))
+		defer func() { _ = nc.Close() }()
+
+		for i := 1; i <= 32; i++ {
+			writeRawFrame(nc, fmt.Sprintf(`{"ServiceMethod":"Blob.Get","Seq":%d}`, i))
+			writeRawFrame(nc, "1048576")
+		}
+		time.Sleep(time.Millisecond * 100)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*200)
+		defer cancel()
+		done := make(chan error, 1)
+		go func() { done <- srv.Shutdown(ctx) }()
+		select {
+		case err := <-done:
+			_assert(err == context.DeadlineExceeded, "expect a deadline error, but got %v", err)
+		case <-time.After(time.Second):
+			t.Fatal("expect Shutdown to return at the deadline")
+		}
+	})
+}
@@ -0,0 +1,262 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"fastRPC/client"
+	"fastRPC/conn"
+	"fastRPC/metadata"
+	"fastRPC/server"
+	"fastRPC/service"
+	"io"
+	"testing"
+	"time"
+)
+
+type Row struct {
+	ID   int
+	Name string
+}
+
+type Export int
+
+var exportCanceled = make(chan error, 1)
+
+// Export.Rows 发送 n 条消息，n 为负数时发送 -n 条消息后返回错误
+func (e *Export) Rows(ctx context.Context, n int, stream service.ServerStream) error {
+	fail := n < 0
+	if fail {
+		n = -n
+	}
+	for i := 0; i < n; i++ {
+		if err := stream.Send(&Row{ID: i, Name: "row"}); err != nil {
+			return err
+		}
+	}
+	if fail {
+		return conn.Errorf(conn.DataLoss, "lost row %d", n)
+	}
+	return metadata.SetReply(ctx, metadata.Pairs("total", "ok"))
+}
+
+// Export.Forever 一直发送消息，直到 Send 返回错误
+func (e *Export) Forever(n int, stream service.ServerStream) error {
+	for i := 0; ; i++ {
+		if err := stream.Send(&Row{ID: i}); err != nil {
+			exportCanceled <- stream.Context().Err()
+			return err
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestClient_CallStream(t *testing.T) {
+	var e Export
+	var calc Calc
+	srv := server.NewServer()
+	_ = srv.Register(&e)
+	_ = srv.Register(&calc)
+	addr := serve(t, srv)
+
+	for _, typ := range []conn.Type{conn.GobType, conn.JsonType} {
+		t.Run(string(typ), func(t *testing.T) {
+			c, err := client.Dial("tcp", addr, &conn.Option{ConnType: typ})
+			_assert(err == nil, "dial error: %v", err)
+			defer func() { _ = c.Close() }()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+			defer cancel()
+
+			stream, err := c.CallStream(ctx, "Export.Rows", 100, new(Row))
+			_assert(err == nil, "failed to call stream: %v", err)
+			// 流式调用进行中，同一个连接上的普通调用不受影响
+			var sum int
+			err = c.Call(ctx, "Calc.Sum", &CalcArgs{Num1: 1, Num2: 2}, &sum)
+			_assert(err == nil && sum == 3, "failed to call Calc.Sum during streaming: %v", err)
+			for i := 0; i < 100; i++ {
+				var row Row
+				err := stream.Recv(&row)
+				_assert(err == nil && row.ID == i && row.Name == "row", "unexpected message %d: %+v, %v", i, row, err)
+			}
+			_assert(stream.Recv(new(Row)) == io.EOF, "expect the end of stream")
+			_assert(stream.ReplyMetadata().Get("total") == "ok", "expect the reply metadata")
+
+			stream, _ = c.CallStream(ctx, "Export.Rows", -3, new(Row))
+			n := 0
+			for err = stream.Recv(new(Row)); err == nil; err = stream.Recv(new(Row)) {
+				n++
+			}
+			var ce *conn.Error
+			_assert(n == 3 && errors.As(err, &ce) && ce.Code == conn.DataLoss, "expect 3 messages and an error, but got %d, %v", n, err)
+		})
+	}
+
+	t.Run("cancel", func(t *testing.T) {
+		c, _ := client.Dial("tcp", addr)
+		defer func() { _ = c.Close() }()
+		ctx, cancel := context.WithCancel(context.Background())
+		stream, _ := c.CallStream(ctx, "Export.Forever", 0, new(Row))
+		for i := 0; i < 10; i++ {
+			_assert(stream.Recv(new(Row)) == nil, "failed to receive message")
+		}
+		cancel()
+		select {
+		case err := <-exportCanceled:
+			_assert(err == context.Canceled, "expect the handler to be canceled, but got %v", err)
+		case <-time.After(time.Second):
+			t.Fatal("expect the handler to stop after canceling the stream")
+		}
+		var err error
+		for err == nil {
+			err = stream.Recv(new(Row))
+		}
+		_assert(conn.CodeOf(err) == conn.Canceled, "expect Canceled, but got %v", err)
+	})
+
+	t.Run("mismatch", func(t *testing.T) {
+		c, _ := client.Dial("tcp", addr)
+		defer func() { _ = c.Close() }()
+		_, err := c.CallStream(context.Background(), "Export.Rows", 1, 1)
+		_assert(conn.CodeOf(err) == conn.InvalidArgument, "expect InvalidArgument for a non-pointer reply, but got %v", err)
+		err = c.Call(context.Background(), "Export.Rows", 1, new(Row))
+		_assert(conn.CodeOf(err) == conn.Unimplemented, "expect an error when calling a streaming method without a stream, but got %v", err)
+		stream, _ := c.CallStream(context.Background(), "Calc.Sum", &CalcArgs{}, new(int))
+		err = stream.Recv(new(int))
+		_assert(conn.CodeOf(err) == conn.Unimplemented, "expect an error when streaming a unary method, but got %v", err)
+	})
+}
+
+type Import int
+
+// Import.Rows 客户端流式方法，返回收到的消息数
+func (i *Import) Rows(ctx context.Context, in <-chan *Row, reply *int) error {
+	for row := range in {
+		if row.ID != *reply {
+			return conn.Errorf(conn.InvalidArgument, "expect row %d, but got %d", *reply, row.ID)
+		}
+		*reply++
+	}
+	return ctx.Err()
+}
+
+// Import.Stall 不读取任何消息，直到 ctx 结束
+func (i *Import) Stall(ctx context.Context, in <-chan *Row, reply *int) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// Import.Echo 双向流，将收到的消息原样发回，客户端半关闭后再发送一条结束消息
+func (i *Import) Echo(ctx context.Context, in <-chan Row, stream service.ServerStream) error {
+	n := 0
+	for row := range in {
+		if err := stream.Send(&row); err != nil {
+			return err
+		}
+		n++
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return stream.Send(&Row{ID: n, Name: "done"})
+}
+
+func TestClient_NewStream(t *testing.T) {
+	var i Import
+	srv := server.NewServer()
+	_ = srv.Register(&i)
+	addr := serve(t, srv)
+
+	for _, typ := range []conn.Type{conn.GobType, conn.JsonType} {
+		t.Run(string(typ), func(t *testing.T) {
+			c, err := client.Dial("tcp", addr, &conn.Option{ConnType: typ})
+			_assert(err == nil, "dial error: %v", err)
+			defer func() { _ = c.Close() }()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+			defer cancel()
+
+			// 消息数超过窗口大小，需要服务端归还额度才能发送完
+			n := conn.StreamWindow*3 + 1
+			stream, err := c.NewStream(ctx, "Import.Rows", new(int))
+			_assert(err == nil, "failed to create stream: %v", err)
+			for id := 0; id < n; id++ {
+				err := stream.Send(&Row{ID: id})
+				_assert(err == nil, "failed to send message %d: %v", id, err)
+			}
+			var total int
+			err = stream.CloseAndRecv(&total)
+			_assert(err == nil && total == n, "expect %d, but got %d, %v", n, total, err)
+			_assert(stream.Send(&Row{}) != nil, "expect an error when sending after CloseSend")
+		})
+	}
+
+	t.Run("bidi", func(t *testing.T) {
+		c, _ := client.Dial("tcp", addr)
+		defer func() { _ = c.Close() }()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+		defer cancel()
+
+		stream, err := c.NewStream(ctx, "Import.Echo", new(Row))
+		_assert(err == nil, "failed to create stream: %v", err)
+		for id := 0; id < 5; id++ {
+			_ = stream.Send(&Row{ID: id, Name: "ping"})
+			var row Row
+			err := stream.Recv(&row)
+			_assert(err == nil && row.ID == id && row.Name == "ping", "unexpected echo %d: %+v, %v", id, row, err)
+		}
+		// 半关闭之后仍然可以接收服务端的消息
+		_assert(stream.CloseSend() == nil, "failed to close send")
+		var row Row
+		err = stream.Recv(&row)
+		_assert(err == nil && row.ID == 5 && row.Name == "done", "unexpected message after CloseSend: %+v, %v", row, err)
+		_assert(stream.Recv(&row) == io.EOF, "expect the end of stream")
+	})
+
+	// 请求没有发出时，Send 和 CloseSend 直接返回失败的原因，不会发送流消息
+	t.Run("not started", func(t *testing.T) {
+		c, _ := client.Dial("tcp", addr)
+		defer func() { _ = c.Close() }()
+		ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+		defer cancel()
+
+		stream, err := c.NewStream(ctx, "Import.Rows", new(int))
+		_assert(err == nil, "failed to create stream: %v", err)
+		err = stream.Send(&Row{})
+		_assert(conn.CodeOf(err) == conn.DeadlineExceeded, "expect DeadlineExceeded, but got %v", err)
+		err = stream.CloseSend()
+		_assert(conn.CodeOf(err) == conn.DeadlineExceeded, "expect DeadlineExceeded, but got %v", err)
+
+		_ = c.Close()
+		stream, err = c.NewStream(context.Background(), "Import.Rows", new(int))
+		_assert(err == nil, "failed to create stream: %v", err)
+		err = stream.Send(&Row{})
+		_assert(err == client.ErrConnNotAvailable, "expect ErrConnNotAvailable, but got %v", err)
+	})
+
+	t.Run("flow control", func(t *testing.T) {
+		c, _ := client.Dial("tcp", addr)
+		defer func() { _ = c.Close() }()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		stream, _ := c.NewStream(ctx, "Import.Stall", new(int))
+		for id := 0; id < conn.StreamWindow; id++ {
+			_assert(stream.Send(&Row{ID: id}) == nil, "failed to send message %d within the window", id)
+		}
+		sent := make(chan error, 1)
+		go func() { sent <- stream.Send(&Row{ID: conn.StreamWindow}) }()
+		select {
+		case err := <-sent:
+			t.Fatalf("Send should block when the window is exhausted, but got %v", err)
+		case <-time.After(time.Millisecond * 100):
+		}
+
+		cancel()
+		select {
+		case err := <-sent:
+			_assert(err == io.EOF, "expect io.EOF after the stream is canceled, but got %v", err)
+		case <-time.After(time.Second):
+			t.Fatal("Send should return after the stream is canceled")
+		}
+		err := stream.Recv(new(int))
+		_assert(conn.CodeOf(err) == conn.Canceled, "expect Canceled, but got %v", err)
+	})
+}
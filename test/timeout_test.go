@@ -0,0 +1,105 @@
+package test
+
+import (
+	"context"
+	"fastRPC/conn"
+	"fastRPC/server"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// Sleeper.Sleep 在 ctx 被取消之前一直等待，Sleeper.Block 则无视 ctx
+type Sleeper int
+
+func (s *Sleeper) Sleep(ctx context.Context, d time.Duration, reply *int) error {
+	select {
+	case <-time.After(d):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Sleeper) Block(d time.Duration, reply *int) error {
+	time.Sleep(d)
+	return nil
+}
+
+// dialTimeoutConn 以 handleTimeout 为 Option.HandleTimeout 建立 gob 连接
+func dialTimeoutConn(t *testing.T, addr string, handleTimeout time.Duration) (net.Conn, conn.Conn) {
+	nc, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	opt := &conn.Option{
+		MagicNumber:   conn.MagicNumber,
+		ConnType:      conn.GobType,
+		Version:       conn.ProtocolVersion,
+		HandleTimeout: handleTimeout,
+	}
+	_ = conn.WriteOption(nc, opt)
+	_, _ = conn.ReadOption(nc)
+	return nc, conn.NewGobConn(conn.NewFramer(nc))
+}
+
+// readTimeoutReplies 读取响应直到 read deadline，确保每个请求只回复一次且都是超时错误，返回收到的响应数
+func readTimeoutReplies(nc net.Conn, cc conn.Conn) int {
+	seen := make(map[uint64]bool)
+	_ = nc.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		var h conn.Header
+		if err := cc.ReadHeader(&h); err != nil {
+			return len(seen) // no more responses before the read deadline
+		}
+		_ = cc.ReadBody(nil)
+		_assert(!seen[h.Seq], "request %d got more than one response", h.Seq)
+		_assert(h.Code == conn.DeadlineExceeded, "expect a timeout error for request %d, but got %q", h.Seq, h.Error)
+		seen[h.Seq] = true
+	}
+}
+
+// TestServer_HandleTimeoutLeak 大量请求超时后，每个请求只回复一次；接收 ctx 的服务方法随之返回，协程数回到初始水平。
+// 不接收 ctx 的服务方法无法被打断（见 handleTimeout），它们只检查回复，不检查协程数
+func TestServer_HandleTimeoutLeak(t *testing.T) {
+	var sleeper Sleeper
+	srv := server.NewServer()
+	_ = srv.Register(&sleeper)
+	addr := serve(t, srv)
+	const n = 100
+
+	t.Run("ctx-aware", func(t *testing.T) {
+		nc, cc := dialTimeoutConn(t, addr, time.Millisecond*50)
+		defer func() { _ = nc.Close() }()
+		time.Sleep(time.Millisecond * 100)
+		baseline := runtime.NumGoroutine()
+
+		for i := 0; i < n; i++ {
+			_ = cc.Write(&conn.Header{ServiceMethod: "Sleeper.Sleep", Seq: uint64(i)}, time.Second*10)
+		}
+		got := readTimeoutReplies(nc, cc)
+		_assert(got == n, "expect %d responses, but got %d", n, got)
+
+		// 回复之后服务方法的协程很快退出，等待它们被调度，而不是等待服务方法执行完
+		deadline := time.Now().Add(time.Second)
+		for runtime.NumGoroutine() > baseline && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond * 10)
+		}
+		_assert(runtime.NumGoroutine() <= baseline, "goroutine leak: %d > %d", runtime.NumGoroutine(), baseline)
+	})
+
+	t.Run("mixed", func(t *testing.T) {
+		nc, cc := dialTimeoutConn(t, addr, time.Millisecond*50)
+		defer func() { _ = nc.Close() }()
+		for i := 0; i < n; i++ {
+			method := "Sleeper.Sleep"
+			if i%2 == 1 {
+				method = "Sleeper.Block"
+			}
+			_ = cc.Write(&conn.Header{ServiceMethod: method, Seq: uint64(i)}, time.Millisecond*200)
+		}
+		got := readTimeoutReplies(nc, cc)
+		_assert(got == n, "expect %d responses, but got %d", n, got)
+	})
+}
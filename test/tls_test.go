@@ -0,0 +1,185 @@
+package test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fastRPC/client"
+	"fastRPC/conn"
+	"fastRPC/server"
+	"math/big"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testCA 在内存中生成的 CA，用来签发测试用的证书
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("generate key error:", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "FastRPC Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal("create certificate error:", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+// issue 签发 CommonName 为 name 的证书，server 为 true 时用于服务端（127.0.0.1）
+func (ca *testCA) issue(t *testing.T, name string, server bool) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("generate key error:", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if server {
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		tmpl.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal("create certificate error:", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// Whoami.Name 返回客户端证书中的身份
+type Whoami int
+
+func (w *Whoami) Name(ctx context.Context, _ int, reply *string) error {
+	peer, ok := server.PeerFromContext(ctx)
+	if !ok {
+		return conn.NewError(conn.Internal, "no peer in context")
+	}
+	*reply = peer.Identity()
+	return nil
+}
+
+func serveTLS(t *testing.T, srv *server.Server, config *tls.Config) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("network error:", err)
+	}
+	go srv.AcceptTLS(l, config)
+	return l.Addr().String()
+}
+
+func TestTLS(t *testing.T) {
+	ca := newTestCA(t)
+	serverCert := ca.issue(t, "server", true)
+
+	var calc Calc
+	var w Whoami
+	srv := server.NewServer()
+	_ = srv.Register(&calc)
+	_ = srv.Register(&w)
+	addr := serveTLS(t, srv, &tls.Config{Certificates: []tls.Certificate{serverCert}})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
+
+	t.Run("tls", func(t *testing.T) {
+		c, err := client.DialTLS("tcp", addr, &tls.Config{RootCAs: ca.pool})
+		_assert(err == nil, "dial error: %v", err)
+		defer func() { _ = c.Close() }()
+		var sum int
+		err = c.Call(ctx, "Calc.Sum", &CalcArgs{Num1: 1, Num2: 2}, &sum)
+		_assert(err == nil && sum == 3, "failed to call Calc.Sum over tls: %v", err)
+		var name string
+		err = c.Call(ctx, "Whoami.Name", 0, &name)
+		_assert(err == nil && name == "", "expect no identity without client certificate, but got %q, %v", name, err)
+	})
+
+	t.Run("untrusted", func(t *testing.T) {
+		_, err := client.DialTLS("tcp", addr, nil)
+		_assert(conn.CodeOf(err) == conn.Unauthenticated, "expect Unauthenticated for an untrusted certificate, but got %v", err)
+		_, err = client.Dial("tcp", addr, &conn.Option{ConnectTimeout: time.Millisecond * 200})
+		_assert(err != nil, "expect an error when dialing a tls server without tls")
+	})
+
+	t.Run("xdial", func(t *testing.T) {
+		d := &client.Dialer{TLSConfig: &tls.Config{RootCAs: ca.pool}}
+		c, err := d.XDial("tls@" + addr)
+		_assert(err == nil, "dial error: %v", err)
+		defer func() { _ = c.Close() }()
+		var sum int
+		err = c.Call(ctx, "Calc.Sum", &CalcArgs{Num1: 3, Num2: 4}, &sum)
+		_assert(err == nil && sum == 7, "failed to call Calc.Sum over tls: %v", err)
+	})
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca := newTestCA(t)
+	serverCert := ca.issue(t, "server", true)
+	agentCert := ca.issue(t, "agent-1", false)
+
+	var w Whoami
+	srv := server.NewServer()
+	_ = srv.Register(&w)
+	var mu sync.Mutex
+	var seen string
+	srv.Use(func(ctx context.Context, info *server.UnaryServerInfo, args interface{}, handler server.UnaryHandler) error {
+		mu.Lock()
+		seen = info.Peer.Identity()
+		mu.Unlock()
+		return handler(ctx, args)
+	})
+	addr := serveTLS(t, srv, &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    ca.pool,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
+
+	c, err := client.DialTLS("tcp", addr, &tls.Config{RootCAs: ca.pool, Certificates: []tls.Certificate{agentCert}})
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = c.Close() }()
+	var name string
+	err = c.Call(ctx, "Whoami.Name", 0, &name)
+	_assert(err == nil && name == "agent-1", "expect identity agent-1, but got %q, %v", name, err)
+	mu.Lock()
+	_assert(seen == "agent-1", "interceptor should see identity agent-1, but got %q", seen)
+	mu.Unlock()
+
+	// 没有客户端证书时无法建立连接
+	anonymous, err := client.DialTLS("tcp", addr, &tls.Config{RootCAs: ca.pool})
+	if err == nil {
+		// TLS 1.3 中客户端可能在服务端校验证书之前完成握手，此时第一次调用失败
+		err = anonymous.Call(ctx, "Whoami.Name", 0, &name)
+		_ = anonymous.Close()
+	}
+	_assert(err != nil, "expect an error without client certificate")
+}
@@ -0,0 +1,78 @@
+package test
+
+import (
+	"context"
+	"fastRPC/client"
+	"fastRPC/load_balance/xclient"
+	"fastRPC/server"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestXClient_Retry 其中一个服务实例已经下线，开启重试的方法会换一个实例重试
+func TestXClient_Retry(t *testing.T) {
+	var calc Calc
+	srv := server.NewServer()
+	_ = srv.Register(&calc)
+	alive := serve(t, srv)
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	dead := l.Addr().String()
+	_ = l.Close()
+
+	d := xclient.NewMultiServerDiscovery([]string{"tcp@" + dead, "tcp@" + alive})
+	xc := xclient.NewXClient(d, xclient.RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	failed := 0
+	for i := 0; i < 4; i++ {
+		if err := xc.Call(ctx, "Calc.Sum", &CalcArgs{Num1: i, Num2: i}, new(int)); err != nil {
+			failed++
+		}
+	}
+	_assert(failed == 2, "expect half of the calls to fail without retry, but %d failed", failed)
+
+	xc.SetRetryPolicies(client.RetryPolicies{"Calc.Sum": {MaxAttempts: 2}})
+	for i := 0; i < 4; i++ {
+		var reply int
+		err := xc.Call(ctx, "Calc.Sum", &CalcArgs{Num1: i, Num2: i}, &reply)
+		_assert(err == nil && reply == 2*i, "expect the call to be retried on another server, but got %v", err)
+	}
+}
+
+// TestXClient_Use 拦截器对已经创建的 Client 和之后创建的 Client 都生效，Broadcast 时每个实例分别经过拦截器
+func TestXClient_Use(t *testing.T) {
+	addrs := make([]string, 2)
+	for i := range addrs {
+		var calc Calc
+		srv := server.NewServer()
+		_ = srv.Register(&calc)
+		addrs[i] = "tcp@" + serve(t, srv)
+	}
+	xc := xclient.NewXClient(xclient.NewMultiServerDiscovery(addrs), xclient.RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	counter := func(n *int32) client.UnaryClientInterceptor {
+		return func(ctx context.Context, serviceMethod string, args, reply interface{}, invoker client.UnaryInvoker) error {
+			atomic.AddInt32(n, 1)
+			return invoker(ctx, serviceMethod, args, reply)
+		}
+	}
+	var before, after int32
+	xc.Use(counter(&before))
+	err := xc.Call(ctx, "Calc.Sum", &CalcArgs{Num1: 1, Num2: 2}, new(int))
+	_assert(err == nil && atomic.LoadInt32(&before) == 1, "expect the interceptor to wrap Call, but got %v", err)
+
+	// 此时已经有一个 Client 被创建，另一个在 Broadcast 时才创建
+	xc.Use(counter(&after))
+	var reply int
+	err = xc.Broadcast(ctx, "Calc.Sum", &CalcArgs{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "failed to broadcast: %v", err)
+	_assert(atomic.LoadInt32(&before) == 3 && atomic.LoadInt32(&after) == 2,
+		"expect both interceptors on every server, but got %d, %d", atomic.LoadInt32(&before), atomic.LoadInt32(&after))
+}